@@ -0,0 +1,305 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// deleting an account removes the user's chirps along with it
+	accountDeletionCascade = "cascade"
+	// deleting an account scrubs the user but keeps their chirps around
+	accountDeletionAnonymize = "anonymize"
+
+	exportPageSize = 500
+	// exports of busy accounts can outlast the server's write timeout
+	exportWriteTimeout = 10 * time.Minute
+)
+
+func (cfg *apiConfig) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	err = checkPasswordHash(r.Context(), params.Password, user.HashedPassword)
+	if err != nil {
+		respondWithError(w, 401, "Incorrect password")
+		return
+	}
+
+	// blobs that have to go once the rows are gone
+	var blobKeys []string
+	if user.AvatarKey.Valid {
+		blobKeys = append(blobKeys, user.AvatarKey.String)
+	}
+
+	if cfg.accountDeletionMode != accountDeletionCascade && cfg.accountDeletionMode != accountDeletionAnonymize {
+		// config validation rules this out; don't guess which one was meant
+		requestLogger(r.Context()).Error("Unknown account deletion mode", "mode", cfg.accountDeletionMode)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if cfg.accountDeletionMode == accountDeletionCascade {
+		attachments, err := cfg.db.GetAttachmentsByUserId(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, 400, "Error deleting user")
+			return
+		}
+		for _, attachment := range attachments {
+			blobKeys = append(blobKeys, attachment.StorageKey, attachment.ThumbnailKey)
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	err = qtx.RevokeAllUserTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error deleting user")
+		return
+	}
+
+	if cfg.accountDeletionMode == accountDeletionAnonymize {
+		_, err = qtx.AnonymizeUser(r.Context(), userID)
+		if err == nil {
+			// the subscription row outlives an anonymized user, so end it here
+			_, err = qtx.EndSubscription(r.Context(), userID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = nil
+			}
+		}
+	} else {
+		// cascade: the user's reposts go away with them, so stop counting them
+		err = qtx.ReleaseRepostCountsByUser(r.Context(), userID)
+		if err == nil {
+			err = qtx.DeleteUser(r.Context(), userID)
+		}
+	}
+	if err != nil {
+		respondWithError(w, 400, "Error deleting user")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	cfg.wsHub.closeUser(userID, wsCloseAccountDeleted, "account deleted")
+
+	for _, key := range blobKeys {
+		err = cfg.blobStore.Delete(r.Context(), key)
+		if err != nil {
+			requestLogger(r.Context()).Error("Error deleting blob", "key", key, "error", err)
+		}
+	}
+
+	w.WriteHeader(204)
+}
+
+type SessionDTO struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// exportUserHandler streams a ZIP with everything we store about the user.
+// Chirps are read page by page and written straight into the archive, so the
+// export never holds more than one page in memory.
+func (cfg *apiConfig) exportUserHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chirpy-export-%s.zip\"", user.ID))
+	w.WriteHeader(200)
+
+	// once the headers are out the only thing left to do on failure is to cut
+	// the archive short, which the client will see as a corrupt zip
+	archive := zip.NewWriter(w)
+
+	err = writeJSONEntry(archive, "profile.json", User{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		AvatarURL:   cfg.avatarURL(user.AvatarKey),
+	})
+	if err != nil {
+		requestLogger(r.Context()).Error("Error exporting profile", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = cfg.exportChirps(r, archive, user.ID)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error exporting chirps", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = cfg.exportSessions(r, archive, user.ID)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error exporting sessions", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = archive.Close()
+	if err != nil {
+		requestLogger(r.Context()).Error("Error finishing export", "user_id", user.ID, "error", err)
+	}
+}
+
+func (cfg *apiConfig) exportChirps(r *http.Request, archive *zip.Writer, userID uuid.UUID) error {
+	entry, err := archive.Create("chirps.json")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(entry, "[")
+	if err != nil {
+		return err
+	}
+
+	first := true
+	afterCreatedAt := time.Time{}
+	afterID := uuid.Nil
+	for {
+		chirps, err := cfg.db.GetChirpsByUserIdPage(r.Context(), database.GetChirpsByUserIdPageParams{
+			UserID:         userID,
+			AfterCreatedAt: afterCreatedAt,
+			AfterID:        afterID,
+			PageSize:       exportPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(chirps) == 0 {
+			break
+		}
+
+		dtos, err := cfg.chirpsToDTOs(r.Context(), chirps, uuid.NullUUID{})
+		if err != nil {
+			return err
+		}
+
+		for _, dto := range dtos {
+			dat, err := json.Marshal(dto)
+			if err != nil {
+				return err
+			}
+
+			if !first {
+				_, err = io.WriteString(entry, ",")
+				if err != nil {
+					return err
+				}
+			}
+			first = false
+
+			_, err = entry.Write(dat)
+			if err != nil {
+				return err
+			}
+		}
+
+		last := chirps[len(chirps)-1]
+		afterCreatedAt = last.CreatedAt
+		afterID = last.ID
+	}
+
+	_, err = io.WriteString(entry, "]")
+	return err
+}
+
+func (cfg *apiConfig) exportSessions(r *http.Request, archive *zip.Writer, userID uuid.UUID) error {
+	tokens, err := cfg.db.GetRefreshTokensByUserId(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// the token values themselves are credentials and stay out of the export
+	sessions := make([]SessionDTO, 0, len(tokens))
+	for _, token := range tokens {
+		session := SessionDTO{
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+		}
+		if token.RevokedAt.Valid {
+			session.RevokedAt = &token.RevokedAt.Time
+		}
+		sessions = append(sessions, session)
+	}
+
+	return writeJSONEntry(archive, "sessions.json", sessions)
+}
+
+func writeJSONEntry(archive *zip.Writer, name string, payload interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(entry).Encode(payload)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/stream"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// accountFixture is a live user the fake database knows about, with the
+// answers deleteUserHandler needs for a cascade delete.
+func accountFixture(t *testing.T, mode string) (*apiConfig, *fakeDB, string) {
+	t.Helper()
+	userID := uuid.New()
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	fake, conn := newFakeDB(t)
+	fake.returns("GetUser", userColumns, []driver.Value{userID.String(), now, now, "a@example.com", hash, false, nil, nil})
+	fake.returns("GetBlockRelatedUserIds", []string{"id"})
+	fake.returns("GetMutedUserIds", []string{"muted_id"})
+	fake.returns("GetAttachmentsByUserId", nil)
+	fake.returns("RevokeAllUserTokens", nil)
+	fake.returns("ReleaseRepostCountsByUser", nil)
+	fake.returns("DeleteUser", nil)
+
+	cfg := &apiConfig{
+		db:                  database.New(conn),
+		dbConn:              conn,
+		secret:              testSecret,
+		accountDeletionMode: mode,
+		metrics:             newAppMetrics(),
+		chirpStream:         stream.NewHub(8, 8),
+		notificationStream:  stream.NewHub(8, 8),
+		wsHub:               newWSHub(),
+	}
+	token, err := auth.MakeJWT(userID, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, fake, token
+}
+
+func deleteAccount(cfg *apiConfig, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("DELETE", "/api/users", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.deleteUserHandler(rec, req)
+	return rec
+}
+
+// A WebSocket opened before the account was deleted mustn't keep streaming
+// until the token expires.
+func TestDeleteAccountClosesWebSockets(t *testing.T) {
+	cfg, _, token := accountFixture(t, accountDeletionCascade)
+	server := httptest.NewServer(http.HandlerFunc(cfg.wsHandler))
+	defer server.Close()
+	defer cfg.wsHub.Shutdown()
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := wsServerMessage{}
+	err = conn.ReadJSON(&msg)
+	if err != nil || msg.Type != "authenticated" {
+		t.Fatalf("got %+v, %v", msg, err)
+	}
+
+	rec := deleteAccount(cfg, token)
+	if rec.Code != 204 {
+		t.Fatalf("delete status = %d: %s", rec.Code, rec.Body)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	closeErr := &websocket.CloseError{}
+	if !errors.As(err, &closeErr) || closeErr.Code != wsCloseAccountDeleted {
+		t.Errorf("read after delete: %v, want close %d", err, wsCloseAccountDeleted)
+	}
+}
+
+// An unknown mode is a configuration bug; deleting anything would be a
+// guess at what was meant.
+func TestDeleteAccountUnknownMode(t *testing.T) {
+	cfg, fake, token := accountFixture(t, "purge")
+
+	rec := deleteAccount(cfg, token)
+	if rec.Code != 500 {
+		t.Errorf("status = %d", rec.Code)
+	}
+	for _, query := range []string{"RevokeAllUserTokens", "DeleteUser", "AnonymizeUser"} {
+		if fake.didRun(query) {
+			t.Errorf("%s ran", query)
+		}
+	}
+}
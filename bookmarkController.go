@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultBookmarksPageSize = 20
+	maxBookmarksPageSize     = 100
+)
+
+type BookmarkDTO struct {
+	Chirp        ChirpDTO   `json:"chirp"`
+	CollectionID *uuid.UUID `json:"collection_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type BookmarkCollectionDTO struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+}
+
+func (cfg *apiConfig) bookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	// the body is optional, it only carries the collection to file the chirp
+	// in. Leaving collection_id out keeps an existing bookmark's collection,
+	// while null takes it out of the collection. The raw value tells the two
+	// apart, a *uuid.UUID would be nil for both.
+	type parameters struct {
+		CollectionID json.RawMessage `json:"collection_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), requestedChirpID)
+	if err != nil || chirp.Status != chirpStatusPublished {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	blocked, err := cfg.db.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+		UserA: userID,
+		UserB: chirp.UserID,
+	})
+	if err != nil || blocked {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	requestedCollectionID := uuid.NullUUID{}
+	if params.CollectionID != nil {
+		err = json.Unmarshal(params.CollectionID, &requestedCollectionID)
+		if err != nil {
+			respondWithError(w, 400, "Invalid collection ID")
+			return
+		}
+	}
+
+	collectionID := uuid.NullUUID{}
+	if requestedCollectionID.Valid {
+		collection, err := cfg.db.GetBookmarkCollection(r.Context(), requestedCollectionID.UUID)
+		if err != nil || collection.UserID != userID {
+			respondWithError(w, 404, "Collection not found")
+			return
+		}
+		collectionID = uuid.NullUUID{UUID: collection.ID, Valid: true}
+	}
+
+	bookmark, err := cfg.db.UpsertBookmark(r.Context(), database.UpsertBookmarkParams{
+		UserID:         userID,
+		ChirpID:        chirp.ID,
+		CollectionID:   collectionID,
+		KeepCollection: params.CollectionID == nil,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error bookmarking chirp")
+		return
+	}
+
+	chirps, err := cfg.chirpsToDTOs(r.Context(), []database.Chirp{chirp}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error bookmarking chirp")
+		return
+	}
+
+	respBody := BookmarkDTO{
+		Chirp:     chirps[0],
+		CreatedAt: bookmark.CreatedAt,
+	}
+	if bookmark.CollectionID.Valid {
+		respBody.CollectionID = &bookmark.CollectionID.UUID
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+func (cfg *apiConfig) unbookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	err = cfg.db.DeleteBookmark(r.Context(), database.DeleteBookmarkParams{
+		UserID:  userID,
+		ChirpID: requestedChirpID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error removing bookmark")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// getBookmarksHandler lists the caller's bookmarks, newest first. Pages are
+// selected with ?limit= and ?offset=, and ?collection_id= narrows the list
+// down to a single collection.
+func (cfg *apiConfig) getBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultBookmarksPageSize, maxBookmarksPageSize)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	collectionID := uuid.NullUUID{}
+	if rawCollectionID := r.URL.Query().Get("collection_id"); rawCollectionID != "" {
+		parsed, err := uuid.Parse(rawCollectionID)
+		if err != nil {
+			respondWithError(w, 400, "Invalid collection id")
+			return
+		}
+		collectionID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	bookmarks, err := cfg.db.GetBookmarksByUser(r.Context(), database.GetBookmarksByUserParams{
+		UserID:       userID,
+		CollectionID: collectionID,
+		PageLimit:    limit,
+		PageOffset:   offset,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving bookmarks")
+		return
+	}
+
+	chirpIDs := make([]uuid.UUID, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		chirpIDs = append(chirpIDs, bookmark.ChirpID)
+	}
+
+	chirps, err := cfg.db.GetChirpsByIds(r.Context(), chirpIDs)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving bookmarks")
+		return
+	}
+
+	dtos, err := cfg.chirpsToDTOs(r.Context(), chirps, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving bookmarks")
+		return
+	}
+
+	dtosByID := make(map[uuid.UUID]ChirpDTO, len(dtos))
+	for _, dto := range dtos {
+		dtosByID[dto.ID] = dto
+	}
+
+	respBody := make([]BookmarkDTO, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		dto := BookmarkDTO{
+			Chirp:     dtosByID[bookmark.ChirpID],
+			CreatedAt: bookmark.CreatedAt,
+		}
+		if bookmark.CollectionID.Valid {
+			dto.CollectionID = &bookmark.CollectionID.UUID
+		}
+		respBody = append(respBody, dto)
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+func (cfg *apiConfig) createBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > 100 {
+		respondWithError(w, 400, "Collection name must be between 1 and 100 characters")
+		return
+	}
+
+	collection, err := cfg.db.CreateBookmarkCollection(r.Context(), database.CreateBookmarkCollectionParams{
+		UserID: userID,
+		Name:   name,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error creating collection")
+		return
+	}
+
+	respondWithJSON(w, 201, BookmarkCollectionDTO{
+		ID:        collection.ID,
+		CreatedAt: collection.CreatedAt,
+		UpdatedAt: collection.UpdatedAt,
+		Name:      collection.Name,
+	})
+}
+
+func (cfg *apiConfig) getBookmarkCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	collections, err := cfg.db.GetBookmarkCollectionsByUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving collections")
+		return
+	}
+
+	respBody := make([]BookmarkCollectionDTO, 0, len(collections))
+	for _, collection := range collections {
+		respBody = append(respBody, BookmarkCollectionDTO{
+			ID:        collection.ID,
+			CreatedAt: collection.CreatedAt,
+			UpdatedAt: collection.UpdatedAt,
+			Name:      collection.Name,
+		})
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+// deleteBookmarkCollectionHandler removes a collection. The bookmarks in it
+// are kept and simply go back to being unfiled.
+func (cfg *apiConfig) deleteBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	collectionID, err := uuid.Parse(r.PathValue("collectionID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid collection ID")
+		return
+	}
+
+	err = cfg.db.DeleteBookmarkCollection(r.Context(), database.DeleteBookmarkCollectionParams{
+		ID:     collectionID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error deleting collection")
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	chirpColumns      = []string{"id", "created_at", "updated_at", "body", "user_id", "kind", "original_chirp_id", "rechirp_count", "quote_count", "status", "publish_at"}
+	collectionColumns = []string{"id", "created_at", "updated_at", "user_id", "name"}
+	bookmarkColumns   = []string{"user_id", "chirp_id", "collection_id", "created_at"}
+)
+
+// Leaving collection_id out keeps the bookmark where it is; null unfiles it.
+func TestBookmarkCollectionOmittedOrNull(t *testing.T) {
+	userID, chirpID, collectionID := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		body           string
+		wantCollection any
+		wantKeep       bool
+		wantCode       int
+	}{
+		{"no body", "", nil, true, 0},
+		{"collection left out", `{}`, nil, true, 0},
+		{"explicit null", `{"collection_id":null}`, nil, false, 0},
+		{"collection given", `{"collection_id":"` + collectionID.String() + `"}`, collectionID.String(), false, 0},
+		{"not a uuid", `{"collection_id":"abc"}`, nil, false, 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, conn := newFakeDB(t)
+			fake.returns("GetUser", userColumns, []driver.Value{userID.String(), now, now, "a@example.com", "hash", false, nil, nil})
+			fake.returns("GetChirp", chirpColumns, []driver.Value{chirpID.String(), now, now, "hi", uuid.NewString(), chirpKindChirp, nil, 0, 0, chirpStatusPublished, nil})
+			fake.returns("IsBlockedEitherWay", []string{"exists"}, []driver.Value{false})
+			fake.returns("GetBookmarkCollection", collectionColumns, []driver.Value{collectionID.String(), now, now, userID.String(), "later"})
+			fake.returns("UpsertBookmark", bookmarkColumns, []driver.Value{userID.String(), chirpID.String(), nil, now})
+			cfg := &apiConfig{db: database.New(conn), secret: testSecret}
+
+			token, err := auth.MakeJWT(userID, testSecret, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("POST", "/api/chirps/"+chirpID.String()+"/bookmark", strings.NewReader(tt.body))
+			req.SetPathValue("chirpID", chirpID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.bookmarkChirpHandler(rec, req)
+
+			if tt.wantCode != 0 {
+				if rec.Code != tt.wantCode || fake.didRun("UpsertBookmark") {
+					t.Errorf("status = %d, upserted %v", rec.Code, fake.didRun("UpsertBookmark"))
+				}
+				return
+			}
+
+			// user_id, chirp_id, collection_id, keep_collection
+			args := fake.argsOf("UpsertBookmark")
+			if len(args) != 4 {
+				t.Fatalf("UpsertBookmark args = %v (status %d: %s)", args, rec.Code, rec.Body)
+			}
+			if args[2] != tt.wantCollection || args[3] != tt.wantKeep {
+				t.Errorf("collection_id = %v, keep_collection = %v; want %v, %v", args[2], args[3], tt.wantCollection, tt.wantKeep)
+			}
+		})
+	}
+}
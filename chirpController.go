@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/chirptext"
 	"github.com/gaschneider/go/httpserver/internal/database"
 	"github.com/google/uuid"
 )
 
+// maxChirpRequestBytes bounds chirp create and rechirp bodies, well above
+// what the longest allowed chirp with a poll takes.
+const maxChirpRequestBytes = 64 << 10
+
 var badWords = map[string]bool{
 	"kerfuffle": true,
 	"sharbert":  true,
@@ -32,12 +38,200 @@ func replaceBadWordsInChirp(chirp string) string {
 	return strings.Join(words, " ")
 }
 
+// cleanChirpBody validates a chirp body against the author's length limit and
+// censors it.
+func cleanChirpBody(body string, maxLength int) (string, error) {
+	if chirptext.TooLong(body, maxLength) {
+		return "", fmt.Errorf("Chirp is too long, the limit is %d characters", maxLength)
+	}
+
+	return replaceBadWordsInChirp(body), nil
+}
+
+// chirpLengthLimit returns how long the user's chirps may be, which depends on
+// whether they are on Chirpy Red.
+func (cfg *apiConfig) chirpLengthLimit(ctx context.Context, userID uuid.UUID) (int, error) {
+	user, err := cfg.db.GetUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if user.IsChirpyRed {
+		return cfg.chirpLengthLimits.red, nil
+	}
+
+	return cfg.chirpLengthLimits.standard, nil
+}
+
 type ChirpDTO struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserId    uuid.UUID `json:"user_id"`
-	Body      string    `json:"body"`
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	UserId      uuid.UUID       `json:"user_id"`
+	Body        string          `json:"body"`
+	Attachments []AttachmentDTO `json:"attachments,omitempty"`
+	// only set for authenticated requests
+	BookmarkedByMe *bool `json:"bookmarked_by_me,omitempty"`
+
+	Kind         string `json:"kind"`
+	RechirpCount int32  `json:"rechirp_count"`
+	QuoteCount   int32  `json:"quote_count"`
+	// rechirps and quotes embed the chirp they point at; when that chirp was
+	// deleted (or its author blocked the viewer) only the tombstone flag is set
+	OriginalChirp   *ChirpDTO `json:"original_chirp,omitempty"`
+	OriginalDeleted bool      `json:"original_deleted,omitempty"`
+
+	// drafts and scheduled chirps are only ever shown to their author
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+
+	Poll *PollDTO `json:"poll,omitempty"`
+	// filled in asynchronously, a chirp fetched right after it was created
+	// usually has no previews yet
+	Previews []LinkPreviewDTO `json:"previews,omitempty"`
+}
+
+// chirpsToDTOs turns chirps into their JSON form, loading everything that hangs
+// off a chirp with one query per kind rather than one per chirp.
+func (cfg *apiConfig) chirpsToDTOs(ctx context.Context, chirps []database.Chirp, viewerID uuid.NullUUID) ([]ChirpDTO, error) {
+	dtos, err := cfg.buildChirpDTOs(ctx, chirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	originalIDs := make([]uuid.UUID, 0)
+	for _, chirp := range chirps {
+		if chirp.Kind != chirpKindChirp && chirp.OriginalChirpID.Valid {
+			originalIDs = append(originalIDs, chirp.OriginalChirpID.UUID)
+		}
+	}
+
+	if len(originalIDs) == 0 {
+		for i, chirp := range chirps {
+			dtos[i].OriginalDeleted = chirp.Kind != chirpKindChirp
+		}
+		return dtos, nil
+	}
+
+	originals, err := cfg.db.GetChirpsByIds(ctx, originalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(map[uuid.UUID]bool)
+	if viewerID.Valid {
+		blockRelated, err := cfg.db.GetBlockRelatedUserIds(ctx, viewerID.UUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range blockRelated {
+			hidden[id] = true
+		}
+	}
+
+	// originals are only embedded one level deep
+	originalDTOs, err := cfg.buildChirpDTOs(ctx, originals, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	originalsByID := make(map[uuid.UUID]*ChirpDTO, len(originalDTOs))
+	for i := range originalDTOs {
+		if !hidden[originalDTOs[i].UserId] {
+			originalsByID[originalDTOs[i].ID] = &originalDTOs[i]
+		}
+	}
+
+	for i, chirp := range chirps {
+		if chirp.Kind == chirpKindChirp {
+			continue
+		}
+		dtos[i].OriginalChirp = originalsByID[chirp.OriginalChirpID.UUID]
+		dtos[i].OriginalDeleted = dtos[i].OriginalChirp == nil
+	}
+
+	return dtos, nil
+}
+
+func (cfg *apiConfig) buildChirpDTOs(ctx context.Context, chirps []database.Chirp, viewerID uuid.NullUUID) ([]ChirpDTO, error) {
+	chirpIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+
+	attachments, err := cfg.db.GetAttachmentsByChirpIds(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentsByChirp := make(map[uuid.UUID][]AttachmentDTO)
+	for _, attachment := range attachments {
+		attachmentsByChirp[attachment.ChirpID] = append(attachmentsByChirp[attachment.ChirpID], cfg.attachmentToDTO(attachment))
+	}
+
+	polls, err := cfg.loadPolls(ctx, chirpIDs, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make([]string, 0, len(chirps))
+	for _, chirp := range chirps {
+		bodies = append(bodies, chirp.Body)
+	}
+
+	previews, err := cfg.loadLinkPreviews(ctx, bodies)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarked := make(map[uuid.UUID]bool)
+	if viewerID.Valid {
+		bookmarkedIDs, err := cfg.db.GetBookmarkedChirpIds(ctx, database.GetBookmarkedChirpIdsParams{
+			UserID:   viewerID.UUID,
+			ChirpIds: chirpIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range bookmarkedIDs {
+			bookmarked[id] = true
+		}
+	}
+
+	dtos := make([]ChirpDTO, 0, len(chirps))
+	for _, chirp := range chirps {
+		dto := ChirpDTO{
+			ID:          chirp.ID,
+			CreatedAt:   chirp.CreatedAt,
+			UpdatedAt:   chirp.UpdatedAt,
+			UserId:      chirp.UserID,
+			Body:        chirp.Body,
+			Attachments: attachmentsByChirp[chirp.ID],
+
+			Kind:         chirp.Kind,
+			RechirpCount: chirp.RechirpCount,
+			QuoteCount:   chirp.QuoteCount,
+
+			Status: chirp.Status,
+
+			Poll: polls[chirp.ID],
+		}
+		if chirp.PublishAt.Valid {
+			dto.PublishAt = &chirp.PublishAt.Time
+		}
+		for _, url := range chirpPreviewURLs(chirp.Body) {
+			if preview, ok := previews[url]; ok {
+				dto.Previews = append(dto.Previews, preview)
+			}
+		}
+		if viewerID.Valid {
+			isBookmarked := bookmarked[chirp.ID]
+			dto.BookmarkedByMe = &isBookmarked
+		}
+		dtos = append(dtos, dto)
+	}
+
+	return dtos, nil
 }
 
 func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,7 +241,7 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+	userID, err := cfg.validateAccessToken(r.Context(), token)
 	if err != nil {
 		respondWithError(w, 401, "Unauthorized")
 		return
@@ -55,25 +249,83 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 
 	type parameters struct {
 		Body string `json:"body"`
+		// a draft is kept until it's published by hand, publish_at in the
+		// future schedules the chirp instead of posting it right away
+		Draft     bool            `json:"draft"`
+		PublishAt *time.Time      `json:"publish_at"`
+		Poll      *pollParameters `json:"poll"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChirpRequestBytes))
 	params := parameters{}
 	err = decoder.Decode(&params)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		respondWithError(w, 413, "Request body too large")
+		return
+	}
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	maxLength, err := cfg.chirpLengthLimit(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	body, err := cleanChirpBody(params.Body, maxLength)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   replaceBadWordsInChirp(params.Body),
-		UserID: userID,
+	status := chirpStatusPublished
+	publishAt := sql.NullTime{}
+	if params.PublishAt != nil {
+		if params.PublishAt.After(time.Now().Add(maxScheduleAhead)) {
+			respondWithError(w, 400, "Chirps can't be scheduled that far ahead")
+			return
+		}
+		if params.PublishAt.After(time.Now()) {
+			status = chirpStatusScheduled
+			publishAt = sql.NullTime{Time: params.PublishAt.UTC(), Valid: true}
+		}
+	}
+	if params.Draft {
+		status = chirpStatusDraft
+	}
+
+	var pollOptions []string
+	if params.Poll != nil {
+		// a draft can carry a publish_at too; either way the poll opens
+		// when the chirp goes out, not now
+		opensAt := time.Now()
+		if publishAt.Valid {
+			opensAt = publishAt.Time
+		}
+		pollOptions, err = validatePoll(*params.Poll, opensAt)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:      body,
+		UserID:    userID,
+		Status:    status,
+		PublishAt: publishAt,
 	})
 
 	if err != nil {
@@ -81,20 +333,42 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	respBody := ChirpDTO{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		UserId:    chirp.UserID,
-		Body:      chirp.Body,
+	if params.Poll != nil {
+		err = createPoll(r.Context(), qtx, chirp.ID, pollOptions, params.Poll.ClosesAt)
+		if err != nil {
+			respondWithError(w, 400, "Error creating poll")
+			return
+		}
+	}
+
+	if chirp.Status == chirpStatusPublished {
+		err = publishEvent(r.Context(), qtx, eventChirpCreated, chirp.UserID, chirpEventData(chirp))
+		if err != nil {
+			respondWithError(w, 400, "Error creating chirp")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	cfg.metrics.chirpsCreated.WithLabelValues(chirp.Kind).Inc()
+
+	respBody, err := cfg.chirpsToDTOs(r.Context(), []database.Chirp{chirp}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirp")
+		return
 	}
 
-	respondWithJSON(w, 201, respBody)
+	respondWithJSON(w, 201, respBody[0])
 }
 
 func (cfg *apiConfig) getAllChirpHandler(w http.ResponseWriter, r *http.Request) {
 	authorId := r.URL.Query().Get("author_id")
 	sortDirection := r.URL.Query().Get("sort")
+	viewerID := cfg.viewerID(r)
 
 	var chirps []database.Chirp
 	var err error
@@ -105,13 +379,16 @@ func (cfg *apiConfig) getAllChirpHandler(w http.ResponseWriter, r *http.Request)
 			respondWithError(w, 400, "Invalid author id")
 			return
 		}
-		chirps, err = cfg.db.GetAllChirpsByUserId(r.Context(), userId)
+		chirps, err = cfg.db.GetAllChirpsByUserId(r.Context(), database.GetAllChirpsByUserIdParams{
+			UserID:   userId,
+			ViewerID: viewerID,
+		})
 	} else {
-		chirps, err = cfg.db.GetAllChirps(r.Context())
+		chirps, err = cfg.db.GetAllChirps(r.Context(), viewerID)
 	}
 
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		requestLogger(r.Context()).Error("Error retrieving chirps", "error", err)
 		respondWithError(w, 400, "Error retrieving chirps")
 		return
 	}
@@ -122,18 +399,10 @@ func (cfg *apiConfig) getAllChirpHandler(w http.ResponseWriter, r *http.Request)
 		})
 	}
 
-	respBody := make([]ChirpDTO, 0)
-
-	for _, chirp := range chirps {
-		jsonChirp := ChirpDTO{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			UserId:    chirp.UserID,
-			Body:      chirp.Body,
-		}
-
-		respBody = append(respBody, jsonChirp)
+	respBody, err := cfg.chirpsToDTOs(r.Context(), chirps, viewerID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirps")
+		return
 	}
 
 	respondWithJSON(w, 200, respBody)
@@ -152,15 +421,31 @@ func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respBody := ChirpDTO{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		UserId:    chirp.UserID,
-		Body:      chirp.Body,
+	viewerID := cfg.viewerID(r)
+	if chirp.Status != chirpStatusPublished && (!viewerID.Valid || viewerID.UUID != chirp.UserID) {
+		respondWithError(w, 404, "Error retrieving chirps")
+		return
 	}
 
-	respondWithJSON(w, 200, respBody)
+	// a blocked user gets the same answer as for a chirp that doesn't exist
+	if viewerID.Valid {
+		blocked, err := cfg.db.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+			UserA: viewerID.UUID,
+			UserB: chirp.UserID,
+		})
+		if err != nil || blocked {
+			respondWithError(w, 404, "Error retrieving chirps")
+			return
+		}
+	}
+
+	respBody, err := cfg.chirpsToDTOs(r.Context(), []database.Chirp{chirp}, viewerID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirps")
+		return
+	}
+
+	respondWithJSON(w, 200, respBody[0])
 }
 
 func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +455,7 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+	userID, err := cfg.validateAccessToken(r.Context(), token)
 	if err != nil {
 		respondWithError(w, 401, "Unauthorized")
 		return
@@ -193,11 +478,65 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), requestedChirpID)
+	// the rows cascade with the chirp, but the files have to be removed by hand
+	attachments, err := cfg.db.GetAttachmentsByChirpId(r.Context(), requestedChirpID)
 	if err != nil {
 		respondWithError(w, 400, "Error deleting chirp")
 		return
 	}
 
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	// reposts of this chirp keep existing and render as tombstones
+	err = qtx.DeleteChirp(r.Context(), requestedChirpID)
+	if err != nil {
+		respondWithError(w, 400, "Error deleting chirp")
+		return
+	}
+
+	if chirp.Kind != chirpKindChirp && chirp.OriginalChirpID.Valid {
+		err = qtx.AdjustRepostCounts(r.Context(), repostCountDelta(chirp.OriginalChirpID.UUID, chirp.Kind, -1))
+		if err != nil {
+			respondWithError(w, 400, "Error deleting chirp")
+			return
+		}
+	}
+
+	// subscribers never heard of drafts, so there's nothing to retract
+	if chirp.Status == chirpStatusPublished {
+		err = publishEvent(r.Context(), qtx, eventChirpDeleted, chirp.UserID, chirpEventPayload{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UserID:    chirp.UserID,
+			Kind:      chirp.Kind,
+		})
+		if err != nil {
+			respondWithError(w, 400, "Error deleting chirp")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	for _, attachment := range attachments {
+		for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+			err = cfg.blobStore.Delete(r.Context(), key)
+			if err != nil {
+				requestLogger(r.Context()).Error("Error deleting blob", "key", key, "error", err)
+			}
+		}
+	}
+
 	w.WriteHeader(204)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	chirpStatusDraft     = "draft"
+	chirpStatusScheduled = "scheduled"
+	chirpStatusPublished = "published"
+
+	maxScheduleAhead    = 365 * 24 * time.Hour
+	publishBatchSize    = 100
+	schedulerPollPeriod = 15 * time.Second
+)
+
+// runChirpScheduler publishes scheduled chirps once they are due. Everything
+// it needs lives in the database, so chirps that came due while the server
+// was down go out on the first tick after a restart.
+func (cfg *apiConfig) runChirpScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.publishDueChirps(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) publishDueChirps(ctx context.Context) {
+	for {
+		published, err := cfg.publishDueChirpsBatch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error publishing scheduled chirps", "error", err)
+			}
+			return
+		}
+
+		if published < publishBatchSize {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) publishDueChirpsBatch(ctx context.Context) (int, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	published, err := qtx.PublishDueChirps(ctx, publishBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, chirp := range published {
+		err = publishEvent(ctx, qtx, eventChirpCreated, chirp.UserID, chirpEventData(chirp))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(published), tx.Commit()
+}
+
+// getUnpublishedChirpsHandler lists the caller's drafts and scheduled chirps.
+func (cfg *apiConfig) getUnpublishedChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	chirps, err := cfg.db.GetUnpublishedChirpsByUserId(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirps")
+		return
+	}
+
+	respBody, err := cfg.chirpsToDTOs(r.Context(), chirps, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirps")
+		return
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+// publishChirpHandler publishes a draft or scheduled chirp right away.
+func (cfg *apiConfig) publishChirpHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), requestedChirpID)
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	if chirp.UserID != userID {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	if chirp.Status == chirpStatusPublished {
+		respondWithError(w, 409, "Chirp is already published")
+		return
+	}
+
+	// the poll was checked against when the chirp was meant to go out;
+	// published now, it mustn't go live already closed
+	poll, err := cfg.db.GetPollByChirpId(r.Context(), chirp.ID)
+	if err == nil && poll.ClosesAt.Before(time.Now().Add(minPollDuration)) {
+		respondWithError(w, 409, "The chirp's poll would already be closed")
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Error publishing chirp")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	// the scheduler may have got to it first, that's fine
+	published, err := qtx.PublishChirp(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, 409, "Chirp is already published")
+		return
+	}
+
+	err = publishEvent(r.Context(), qtx, eventChirpCreated, published.UserID, chirpEventData(published))
+	if err != nil {
+		respondWithError(w, 400, "Error publishing chirp")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respBody, err := cfg.chirpsToDTOs(r.Context(), []database.Chirp{published}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirp")
+		return
+	}
+
+	respondWithJSON(w, 200, respBody[0])
+}
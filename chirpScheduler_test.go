@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+var pollColumns = []string{"id", "created_at", "chirp_id", "closes_at"}
+
+func schedulerFixture(t *testing.T) (*apiConfig, *fakeDB, uuid.UUID, string) {
+	t.Helper()
+	userID := uuid.New()
+	now := time.Now()
+	fake, conn := newFakeDB(t)
+	fake.returns("GetUser", userColumns, []driver.Value{userID.String(), now, now, "a@example.com", "hash", false, nil, nil})
+	cfg := &apiConfig{
+		db:                database.New(conn),
+		dbConn:            conn,
+		secret:            testSecret,
+		chirpLengthLimits: chirpLengthLimits{standard: 140, red: 280},
+		metrics:           newAppMetrics(),
+	}
+	token, err := auth.MakeJWT(userID, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, fake, userID, token
+}
+
+// A poll opens when its chirp goes out, so its window is checked against
+// publish_at for drafts as well as scheduled chirps.
+func TestPollWindowStartsAtPublishAt(t *testing.T) {
+	publishAt := time.Now().Add(48 * time.Hour).UTC()
+
+	tests := []struct {
+		name     string
+		draft    bool
+		closesAt time.Time
+		ok       bool
+	}{
+		{"scheduled, closes before publish", false, publishAt.Add(-24 * time.Hour), false},
+		{"draft, closes before publish", true, publishAt.Add(-24 * time.Hour), false},
+		{"draft, closes right after publish", true, publishAt.Add(time.Minute), false},
+		{"draft, more than 7 days after now but not after publish", true, publishAt.Add(6 * 24 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake, _, token := schedulerFixture(t)
+
+			body := fmt.Sprintf(`{"body":"vote","draft":%v,"publish_at":%q,"poll":{"options":["a","b"],"closes_at":%q}}`,
+				tt.draft, publishAt.Format(time.RFC3339), tt.closesAt.Format(time.RFC3339))
+			req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.createChirpHandler(rec, req)
+
+			// the fake has no answer for CreateChirp, so getting that far
+			// means the poll passed validation
+			if accepted := fake.didRun("CreateChirp"); accepted != tt.ok {
+				t.Errorf("poll accepted = %v (status %d: %s)", accepted, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPublishDraftWithClosedPoll(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		closesAt time.Time
+		ok       bool
+	}{
+		{"poll already closed", now.Add(-time.Hour), false},
+		{"poll closes in a minute", now.Add(time.Minute), false},
+		{"poll still open for a day", now.Add(24 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake, userID, token := schedulerFixture(t)
+			chirpID := uuid.New()
+			fake.returns("GetChirp", chirpColumns, []driver.Value{chirpID.String(), now, now, "vote", userID.String(), chirpKindChirp, nil, 0, 0, chirpStatusDraft, nil})
+			fake.returns("GetPollByChirpId", pollColumns, []driver.Value{uuid.NewString(), now, chirpID.String(), tt.closesAt})
+
+			req := httptest.NewRequest("POST", "/api/chirps/"+chirpID.String()+"/publish", nil)
+			req.SetPathValue("chirpID", chirpID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.publishChirpHandler(rec, req)
+
+			if published := fake.didRun("PublishChirp"); published != tt.ok {
+				t.Errorf("published = %v (status %d: %s)", published, rec.Code, rec.Body)
+			}
+			if !tt.ok && rec.Code != 409 {
+				t.Errorf("status = %d", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/gaschneider/go/httpserver/internal/stream"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	chirpStreamChannel     = "chirp_stream"
+	chirpStreamRingSize    = 1000
+	chirpStreamClientQueue = 64
+
+	notificationStreamChannel     = "notification_stream"
+	notificationStreamRingSize    = 1000
+	notificationStreamClientQueue = 64
+
+	sseRetryMillis       = 3000
+	sseHeartbeatInterval = 15 * time.Second
+	// a client that can't take a write for this long is cut off
+	sseWriteTimeout = 10 * time.Second
+
+	listenerPingInterval = 90 * time.Second
+)
+
+type chirpStreamNotification struct {
+	EventID uuid.UUID `json:"event_id"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+}
+
+type notificationStreamNotification struct {
+	RecipientID  uuid.UUID       `json:"recipient_id"`
+	Notification NotificationDTO `json:"notification"`
+}
+
+// notifyChirpStream is the event bus subscriber that announces new chirps
+// over Postgres NOTIFY. Only one instance dispatches any given event, but
+// every instance is listening and feeds its own streams.
+func (cfg *apiConfig) notifyChirpStream(ctx context.Context, event events.Event) error {
+	payload := chirpEventPayload{}
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	notification, err := json.Marshal(chirpStreamNotification{
+		EventID: event.ID,
+		ChirpID: payload.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.db.NotifyChirpStream(ctx, string(notification))
+}
+
+// runStreamListener LISTENs for new chirps and notifications and publishes
+// them to the local stream hubs. Whatever is announced while the connection
+// is down is not replayed; clients notice the gap when they resume.
+func (cfg *apiConfig) runStreamListener(ctx context.Context, dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Stream listener connection problem", "error", err)
+		}
+	})
+	defer listener.Close()
+
+	for _, channel := range []string{chirpStreamChannel, notificationStreamChannel} {
+		err := listener.Listen(channel)
+		if err != nil {
+			slog.Error("Error listening for stream notifications", "channel", channel, "error", err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-listener.Notify:
+			// nil means the connection was re-established
+			if notification == nil {
+				continue
+			}
+			switch notification.Channel {
+			case chirpStreamChannel:
+				cfg.publishStreamedChirp(ctx, notification.Extra)
+			case notificationStreamChannel:
+				cfg.publishStreamedNotification(notification.Extra)
+			}
+		case <-time.After(listenerPingInterval):
+			go listener.Ping()
+		}
+	}
+}
+
+func (cfg *apiConfig) publishStreamedNotification(payload string) {
+	notification := notificationStreamNotification{}
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		slog.Error("Error decoding notification stream notification", "error", err)
+		return
+	}
+
+	// an aggregated notification is announced again whenever it grows
+	cfg.notificationStream.Publish(stream.Event{
+		ID:   fmt.Sprintf("%s-%d", notification.Notification.ID, notification.Notification.UpdatedAt.UnixNano()),
+		Type: "notification",
+		Data: notification,
+	})
+}
+
+func (cfg *apiConfig) publishStreamedChirp(ctx context.Context, payload string) {
+	notification := chirpStreamNotification{}
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		slog.Error("Error decoding chirp stream notification", "error", err)
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(ctx, notification.ChirpID)
+	if err != nil || chirp.Status != chirpStatusPublished {
+		// deleted again already
+		return
+	}
+
+	// built once for everyone, viewer specific bits are patched per stream
+	dtos, err := cfg.chirpsToDTOs(ctx, []database.Chirp{chirp}, uuid.NullUUID{})
+	if err != nil {
+		slog.Error("Error building streamed chirp", "chirp_id", chirp.ID, "error", err)
+		return
+	}
+
+	cfg.chirpStream.Publish(stream.Event{
+		ID:   notification.EventID.String(),
+		Type: "chirp",
+		Data: dtos[0],
+	})
+}
+
+// streamHiddenUsers returns the users whose chirps a viewer's live streams
+// leave out: the ones blocked either way and the ones the viewer muted.
+func (cfg *apiConfig) streamHiddenUsers(ctx context.Context, viewerID uuid.NullUUID) (map[uuid.UUID]bool, error) {
+	hidden := make(map[uuid.UUID]bool)
+	if !viewerID.Valid {
+		return hidden, nil
+	}
+
+	blockRelated, err := cfg.db.GetBlockRelatedUserIds(ctx, viewerID.UUID)
+	if err != nil {
+		return nil, err
+	}
+	muted, err := cfg.db.GetMutedUserIds(ctx, viewerID.UUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range append(blockRelated, muted...) {
+		hidden[id] = true
+	}
+
+	return hidden, nil
+}
+
+// visibleStreamedChirp returns the chirp carried by a chirp stream event as
+// the viewer may see it, or false when it's from a hidden user. A repost of
+// a hidden user's chirp is shown with a tombstone.
+func visibleStreamedChirp(event stream.Event, hidden map[uuid.UUID]bool) (ChirpDTO, bool) {
+	dto, ok := event.Data.(ChirpDTO)
+	if !ok || hidden[dto.UserId] {
+		return ChirpDTO{}, false
+	}
+	if dto.OriginalChirp != nil && hidden[dto.OriginalChirp.UserId] {
+		dto.OriginalChirp = nil
+		dto.OriginalDeleted = true
+	}
+
+	return dto, true
+}
+
+// getChirpStreamHandler streams new chirps as Server-Sent Events. ?author_id=
+// narrows the stream down to one author. Signed in viewers don't get chirps
+// from users they blocked, muted or were blocked by, as of when the stream
+// was opened.
+func (cfg *apiConfig) getChirpStreamHandler(w http.ResponseWriter, r *http.Request) {
+	authorID := uuid.NullUUID{}
+	if rawAuthorID := r.URL.Query().Get("author_id"); rawAuthorID != "" {
+		parsed, err := uuid.Parse(rawAuthorID)
+		if err != nil {
+			respondWithError(w, 400, "Invalid author id")
+			return
+		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	hidden, err := cfg.streamHiddenUsers(r.Context(), cfg.viewerID(r))
+	if err != nil {
+		respondWithError(w, 400, "Error opening stream")
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	client, backlog, resumed := cfg.chirpStream.Subscribe(lastEventID)
+	defer cfg.chirpStream.Unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) bool {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		_, err := fmt.Fprintf(w, format, args...)
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err == nil
+	}
+
+	if !write("retry: %d\n\n", sseRetryMillis) {
+		return
+	}
+	// the client asked to resume from an event we no longer have, so it
+	// should refetch instead of assuming it saw everything
+	if lastEventID != "" && !resumed && !write("event: reset\ndata: {}\n\n") {
+		return
+	}
+
+	send := func(event stream.Event) bool {
+		dto, ok := visibleStreamedChirp(event, hidden)
+		if !ok || (authorID.Valid && dto.UserId != authorID.UUID) {
+			return true
+		}
+
+		data, err := json.Marshal(dto)
+		if err != nil {
+			return false
+		}
+		return write("id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	}
+
+	for _, event := range backlog {
+		if !send(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case event, ok := <-client.Events:
+			// closed when we fell behind or the server is shutting down
+			if !ok || !send(event) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/stream"
+	"github.com/google/uuid"
+)
+
+// Live streams hide the same users GET /api/chirps does, whether or not the
+// viewer asked for one author.
+func TestStreamHidesMutedAndBlockedUsers(t *testing.T) {
+	viewer, muted, blocked, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	fake, conn := newFakeDB(t)
+	fake.returns("GetBlockRelatedUserIds", []string{"id"}, []driver.Value{blocked.String()})
+	fake.returns("GetMutedUserIds", []string{"muted_id"}, []driver.Value{muted.String()})
+	cfg := &apiConfig{db: database.New(conn)}
+
+	hidden, err := cfg.streamHiddenUsers(context.Background(), uuid.NullUUID{UUID: viewer, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chirpBy := func(author uuid.UUID) stream.Event {
+		return stream.Event{Type: "chirp", Data: ChirpDTO{ID: uuid.New(), UserId: author}}
+	}
+	repostOf := func(author uuid.UUID) stream.Event {
+		original := ChirpDTO{ID: uuid.New(), UserId: author}
+		return stream.Event{Type: "chirp", Data: ChirpDTO{ID: uuid.New(), UserId: other, OriginalChirp: &original}}
+	}
+
+	for name, author := range map[string]uuid.UUID{"muted": muted, "blocked": blocked} {
+		if _, visible := visibleStreamedChirp(chirpBy(author), hidden); visible {
+			t.Errorf("%s author's chirp is visible", name)
+		}
+		dto, visible := visibleStreamedChirp(repostOf(author), hidden)
+		if !visible || dto.OriginalChirp != nil || !dto.OriginalDeleted {
+			t.Errorf("repost of %s author's chirp: visible %v, original %v", name, visible, dto.OriginalChirp)
+		}
+	}
+	if _, visible := visibleStreamedChirp(chirpBy(other), hidden); !visible {
+		t.Error("other author's chirp is hidden")
+	}
+}
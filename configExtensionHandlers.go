@@ -2,23 +2,135 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+)
+
+const (
+	defaultPageHitDays = 30
+	maxPageHitDays     = 366
+	maxPageHitPaths    = 100
 )
 
-func (cfg *apiConfig) displayCountRequestsHandler(w http.ResponseWriter, _ *http.Request) {
+type PageHitsDTO struct {
+	Total  int64            `json:"total"`
+	Days   int              `json:"days"`
+	ByPath []PathPageHitDTO `json:"by_path"`
+	ByDay  []DayPageHitDTO  `json:"by_day"`
+}
+
+type PathPageHitDTO struct {
+	Path string `json:"path"`
+	Hits int64  `json:"hits"`
+}
+
+type DayPageHitDTO struct {
+	Day  string `json:"day"`
+	Hits int64  `json:"hits"`
+}
+
+// displayCountRequestsHandler shows file server hits across all instances,
+// as HTML by default or as JSON with ?format=json or an application/json
+// Accept header. The breakdowns cover the last ?days= days (30 by default);
+// the total is all time and includes hits this instance hasn't flushed yet.
+func (cfg *apiConfig) displayCountRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	days := defaultPageHitDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageHitDays {
+			respondWithError(w, 400, fmt.Sprintf("days must be between 1 and %d", maxPageHitDays))
+			return
+		}
+		days = parsed
+	}
+
+	hits, err := cfg.pageHitsReport(r, days)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		respondWithJSON(w, 200, hits)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", cfg.fileserverHits.Load())
+	fmt.Fprintf(w, "<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p>", hits.Total)
+	fmt.Fprintf(w, "<h2>By path, last %d days</h2><table>", hits.Days)
+	for _, hit := range hits.ByPath {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d</td></tr>", html.EscapeString(hit.Path), hit.Hits)
+	}
+	fmt.Fprintf(w, "</table><h2>By day</h2><table>")
+	for _, hit := range hits.ByDay {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d</td></tr>", hit.Day, hit.Hits)
+	}
+	fmt.Fprintf(w, "</table></body></html>")
 }
 
+func (cfg *apiConfig) pageHitsReport(r *http.Request, days int) (PageHitsDTO, error) {
+	total, err := cfg.db.GetTotalPageHits(r.Context())
+	if err != nil {
+		return PageHitsDTO{}, err
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Truncate(24 * time.Hour)
+	byPath, err := cfg.db.ListPageHitsByPath(r.Context(), database.ListPageHitsByPathParams{
+		Since:   since,
+		MaxRows: maxPageHitPaths,
+	})
+	if err != nil {
+		return PageHitsDTO{}, err
+	}
+
+	byDay, err := cfg.db.ListPageHitsByDay(r.Context(), since)
+	if err != nil {
+		return PageHitsDTO{}, err
+	}
+
+	hits := PageHitsDTO{
+		Total:  total + cfg.pageHits.pendingTotal(),
+		Days:   days,
+		ByPath: []PathPageHitDTO{},
+		ByDay:  []DayPageHitDTO{},
+	}
+	for _, row := range byPath {
+		hits.ByPath = append(hits.ByPath, PathPageHitDTO{Path: row.Path, Hits: row.Hits})
+	}
+	for _, row := range byDay {
+		hits.ByDay = append(hits.ByDay, DayPageHitDTO{Day: row.Day.Format(pageHitDayFormat), Hits: row.Hits})
+	}
+
+	return hits, nil
+}
+
+// resetCountRequestsHandler zeroes the hit counters. Other instances may
+// still flush hits they counted before the reset.
 func (cfg *apiConfig) resetCountRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	if cfg.platform != "dev" {
-		w.WriteHeader(http.StatusForbidden)
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
 		return
 	}
-	cfg.fileserverHits.Swap(0)
-	cfg.db.DeleteAllUsers(r.Context())
+
+	cfg.pageHits.reset()
+	err := cfg.db.ResetPageHits(r.Context())
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	io.WriteString(w, "OK")
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/google/uuid"
+)
+
+const (
+	eventChirpCreated   = "chirp.created"
+	eventChirpDeleted   = "chirp.deleted"
+	eventUserCreated    = "user.created"
+	eventUserUpgraded   = "user.upgraded"
+	eventUserDowngraded = "user.downgraded"
+
+	domainEventLeaseSeconds = 60
+	eventDispatchInterval   = time.Second
+)
+
+type chirpEventPayload struct {
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Body            string     `json:"body,omitempty"`
+	Kind            string     `json:"kind"`
+	OriginalChirpID *uuid.UUID `json:"original_chirp_id,omitempty"`
+}
+
+type userEventPayload struct {
+	UserID      uuid.UUID `json:"user_id"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+func chirpEventData(chirp database.Chirp) chirpEventPayload {
+	payload := chirpEventPayload{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UserID:    chirp.UserID,
+		Body:      chirp.Body,
+		Kind:      chirp.Kind,
+	}
+	if chirp.OriginalChirpID.Valid {
+		payload.OriginalChirpID = &chirp.OriginalChirpID.UUID
+	}
+
+	return payload
+}
+
+// publishEvent writes a domain event to the outbox. Callers pass the
+// transaction that makes the change the event is about, so the event is
+// published exactly when the change is committed.
+func publishEvent(ctx context.Context, qtx *database.Queries, eventType string, userID uuid.UUID, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return qtx.CreateDomainEvent(ctx, database.CreateDomainEventParams{
+		EventType: eventType,
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		Payload:   string(payload),
+	})
+}
+
+// subscribeToEvents wires up everything that reacts to domain events.
+func (cfg *apiConfig) subscribeToEvents(bus *events.Bus) {
+	outbound := make([]string, 0, len(outboundEventTypes))
+	for eventType := range outboundEventTypes {
+		outbound = append(outbound, eventType)
+	}
+	bus.Subscribe("webhooks", cfg.enqueueWebhookDeliveries, outbound...)
+	bus.Subscribe("link_previews", cfg.fetchChirpLinkPreviews, eventChirpCreated)
+	bus.Subscribe("notifications", cfg.notifyChirpCreated, eventChirpCreated)
+	bus.Subscribe("chirp_stream", cfg.notifyChirpStream, eventChirpCreated)
+}
+
+// fetchChirpLinkPreviews queues the links of a new chirp for previewing.
+func (cfg *apiConfig) fetchChirpLinkPreviews(_ context.Context, event events.Event) error {
+	payload := chirpEventPayload{}
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	cfg.linkPreviews.Enqueue(chirpPreviewURLs(payload.Body)...)
+	return nil
+}
+
+// domainEventStore is the outbox in the domain_events table.
+type domainEventStore struct {
+	db *database.Queries
+}
+
+func (s domainEventStore) ClaimDue(ctx context.Context, limit int) ([]events.Event, error) {
+	rows, err := s.db.ClaimDueDomainEvents(ctx, database.ClaimDueDomainEventsParams{
+		LeaseSeconds: domainEventLeaseSeconds,
+		BatchSize:    int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]events.Event, 0, len(rows))
+	for _, row := range rows {
+		claimed = append(claimed, events.Event{
+			ID:        row.ID,
+			Type:      row.EventType,
+			CreatedAt: row.CreatedAt,
+			UserID:    row.UserID,
+			Payload:   json.RawMessage(row.Payload),
+			Attempts:  int(row.Attempts),
+		})
+	}
+
+	return claimed, nil
+}
+
+func (s domainEventStore) IsDelivered(ctx context.Context, eventID uuid.UUID, subscriber string) (bool, error) {
+	return s.db.IsDomainEventDelivered(ctx, database.IsDomainEventDeliveredParams{
+		EventID:    eventID,
+		Subscriber: subscriber,
+	})
+}
+
+func (s domainEventStore) MarkDelivered(ctx context.Context, eventID uuid.UUID, subscriber string) error {
+	return s.db.CreateDomainEventDelivery(ctx, database.CreateDomainEventDeliveryParams{
+		EventID:    eventID,
+		Subscriber: subscriber,
+	})
+}
+
+func (s domainEventStore) Complete(ctx context.Context, eventID uuid.UUID) error {
+	return s.db.MarkDomainEventDispatched(ctx, eventID)
+}
+
+func (s domainEventStore) Retry(ctx context.Context, eventID uuid.UUID, next time.Time, reason string) error {
+	return s.db.RetryDomainEvent(ctx, database.RetryDomainEventParams{
+		ID:            eventID,
+		NextAttemptAt: next,
+		LastError:     sql.NullString{String: reason, Valid: true},
+	})
+}
+
+func (s domainEventStore) Abandon(ctx context.Context, eventID uuid.UUID, reason string) error {
+	return s.db.AbandonDomainEvent(ctx, database.AbandonDomainEventParams{
+		ID:        eventID,
+		LastError: sql.NullString{String: reason, Valid: true},
+	})
+}
+
+func (s domainEventStore) Prune(ctx context.Context, before time.Time) error {
+	return s.db.DeleteDispatchedDomainEvents(ctx, sql.NullTime{Time: before, Valid: true})
+}
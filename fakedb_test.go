@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeDB is an in-memory database/sql driver for handler tests. Queries are
+// answered by name, the sqlc "-- name:" comment, or by their full text for
+// hand-written SQL. Anything without an answer fails.
+type fakeDB struct {
+	mu      sync.Mutex
+	answers map[string]fakeAnswer
+	ran     []string
+	args    map[string][]any
+	pingErr error
+}
+
+type fakeAnswer struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+var queryName = regexp.MustCompile(`^-- name: (\w+)`)
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+	fake := &fakeDB{answers: map[string]fakeAnswer{}, args: map[string][]any{}}
+	conn := sql.OpenDB(fakeConnector{fake})
+	t.Cleanup(func() { conn.Close() })
+	return fake, conn
+}
+
+// returns answers the query with rows of columns.
+func (f *fakeDB) returns(name string, columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[name] = fakeAnswer{columns: columns, rows: rows}
+}
+
+// fails answers the query with err.
+func (f *fakeDB) fails(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[name] = fakeAnswer{err: err}
+}
+
+// pingFails makes every ping return err.
+func (f *fakeDB) pingFails(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pingErr = err
+}
+
+// didRun reports whether the query was run.
+func (f *fakeDB) didRun(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Contains(f.ran, name)
+}
+
+// argsOf returns the arguments the query last ran with.
+func (f *fakeDB) argsOf(name string) []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.args[name]
+}
+
+func (f *fakeDB) answer(query string, args []driver.NamedValue) (fakeAnswer, error) {
+	name := query
+	if m := queryName.FindStringSubmatch(query); m != nil {
+		name = m[1]
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ran = append(f.ran, name)
+	f.args[name] = nil
+	for _, arg := range args {
+		f.args[name] = append(f.args[name], arg.Value)
+	}
+	answer, ok := f.answers[name]
+	if !ok {
+		return fakeAnswer{}, fmt.Errorf("fakedb: unexpected query %s", name)
+	}
+	return answer, answer.err
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakedb: use sql.OpenDB")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) Ping(context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.db.pingErr
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	answer, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{answer: answer}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	answer, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(answer.rows)), nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return fakeConn{s.db}.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return fakeConn{s.db}.QueryContext(context.Background(), s.query, nil)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	answer fakeAnswer
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.answer.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.answer.rows) {
+		return io.EOF
+	}
+	copy(dest, r.answer.rows[r.next])
+	r.next++
+	return nil
+}
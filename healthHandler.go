@@ -1,12 +1,142 @@
 package main
 
 import (
+	"context"
+	"embed"
 	"io"
+	"io/fs"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+const readinessDBTimeout = 2 * time.Second
+
+// the migrations ship with the binary so readiness can tell whether the
+// database has caught up with the code
+//
+//go:embed sql/schema/*.sql
+var schemaFS embed.FS
+
+// healthHandler is kept for existing probes; it behaves like livez.
 func healthHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	io.WriteString(w, "OK")
 }
+
+// livezHandler only says the process is up and serving. It deliberately
+// checks nothing else, so a database outage doesn't get every instance
+// restarted.
+func livezHandler(w http.ResponseWriter, _ *http.Request) {
+	respondWithJSON(w, 200, map[string]string{"status": "ok"})
+}
+
+// readiness is the state readyz reports besides its live checks.
+type readiness struct {
+	shuttingDown      atomic.Bool
+	expectedMigration int64
+}
+
+func newReadiness() *readiness {
+	return &readiness{expectedMigration: latestMigration()}
+}
+
+// markShuttingDown makes readyz fail so load balancers stop sending new
+// requests while the ones in flight finish.
+func (rd *readiness) markShuttingDown() {
+	rd.shuttingDown.Store(true)
+}
+
+// latestMigration is the highest goose version among the embedded
+// migrations, taken from their "019_..." file name prefixes.
+func latestMigration() int64 {
+	names, _ := fs.Glob(schemaFS, "sql/schema/*.sql")
+
+	var latest int64
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(strings.TrimPrefix(name, "sql/schema/"), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err == nil && version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+type ReadinessDTO struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+	Version    *int64  `json:"version,omitempty"`
+	Expected   *int64  `json:"expected,omitempty"`
+}
+
+// readyzHandler reports whether this instance should get traffic: it isn't
+// shutting down, Postgres answers in time, and every migration the code
+// expects has been applied. The response is 200 or 503 with the result of
+// each check.
+func (cfg *apiConfig) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := ReadinessDTO{Status: "ready", Checks: map[string]ReadinessCheck{}}
+
+	if cfg.readiness.shuttingDown.Load() {
+		resp.Checks["shutdown"] = ReadinessCheck{Status: "fail", Error: "shutting down"}
+	} else {
+		resp.Checks["shutdown"] = ReadinessCheck{Status: "ok"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessDBTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := cfg.dbConn.PingContext(ctx)
+	database := ReadinessCheck{Status: "ok", DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		// the driver's message can name hosts and users, and readyz has no
+		// auth, so the details only go to the log
+		requestLogger(r.Context()).Error("Readiness database ping failed", "error", err)
+		database.Status = "fail"
+		database.Error = "unreachable"
+	}
+	resp.Checks["database"] = database
+
+	migrations := ReadinessCheck{Status: "ok", Expected: &cfg.readiness.expectedMigration}
+	if err != nil {
+		migrations.Status = "fail"
+		migrations.Error = "database unavailable"
+	} else {
+		var version int64
+		// goose's bookkeeping table, which isn't part of the sqlc schema
+		err = cfg.dbConn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied").Scan(&version)
+		if err != nil {
+			requestLogger(r.Context()).Error("Readiness migration query failed", "error", err)
+			migrations.Status = "fail"
+			migrations.Error = "query failed"
+		} else {
+			migrations.Version = &version
+			if version < cfg.readiness.expectedMigration {
+				migrations.Status = "fail"
+				migrations.Error = "pending migrations"
+			}
+		}
+	}
+	resp.Checks["migrations"] = migrations
+
+	code := 200
+	for _, check := range resp.Checks {
+		if check.Status != "ok" {
+			resp.Status = "unready"
+			code = 503
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, resp)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const migrationVersionQuery = "SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied"
+
+// readyz has no auth, so driver errors, which can name hosts and users,
+// must stay out of the response.
+func TestReadyzHidesDriverErrors(t *testing.T) {
+	const secret = `dial tcp 10.0.0.7:5432: password authentication failed for user "chirpy"`
+
+	tests := []struct {
+		name  string
+		setup func(*fakeDB)
+		want  map[string]string
+	}{
+		{
+			name:  "ping fails",
+			setup: func(f *fakeDB) { f.pingFails(errors.New(secret)) },
+			want:  map[string]string{"database": "unreachable", "migrations": "database unavailable"},
+		},
+		{
+			name:  "migration query fails",
+			setup: func(f *fakeDB) { f.fails(migrationVersionQuery, errors.New(secret)) },
+			want:  map[string]string{"database": "", "migrations": "query failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, conn := newFakeDB(t)
+			tt.setup(fake)
+			cfg := &apiConfig{dbConn: conn, readiness: newReadiness()}
+
+			rec := httptest.NewRecorder()
+			cfg.readyzHandler(rec, httptest.NewRequest("GET", "/api/readyz", nil))
+
+			if rec.Code != 503 {
+				t.Errorf("status = %d", rec.Code)
+			}
+			if strings.Contains(rec.Body.String(), "10.0.0.7") || strings.Contains(rec.Body.String(), "chirpy") {
+				t.Errorf("response leaks the driver error: %s", rec.Body)
+			}
+
+			var resp ReadinessDTO
+			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for check, want := range tt.want {
+				if got := resp.Checks[check].Error; got != want {
+					t.Errorf("%s error = %q, want %q", check, got, want)
+				}
+			}
+		})
+	}
+}
@@ -69,6 +69,19 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return userId, nil
 }
 
+// JWTExpiresAt returns when a token that passes ValidateJWT expires.
+func JWTExpiresAt(tokenString, tokenSecret string) (time.Time, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(tokenSecret), nil
+	})
+	if err != nil || !token.Valid || claims.ExpiresAt == nil {
+		return time.Time{}, fmt.Errorf("invalid token")
+	}
+
+	return claims.ExpiresAt.Time, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 
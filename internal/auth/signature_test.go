@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	const (
+		secret    = "whsec_current"
+		oldSecret = "whsec_previous"
+		tolerance = 5 * time.Minute
+	)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	now := time.Now().Unix()
+	stamp := strconv.FormatInt(now, 10)
+	valid := "v1=" + SignPayload(secret, now, body)
+
+	tests := []struct {
+		name      string
+		secrets   []string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{"valid", []string{secret}, stamp, valid, body, nil},
+		{"tampered body", []string{secret}, stamp, valid, []byte(`{"event":"user.upgraded","data":{"user_id":"00000000-0000-0000-0000-000000000000"}}`), ErrInvalidSignature},
+		{"wrong secret", []string{"whsec_other"}, stamp, valid, body, ErrInvalidSignature},
+		{"signature for another timestamp", []string{secret}, strconv.FormatInt(now-1, 10), valid, body, ErrInvalidSignature},
+		{"stale timestamp", []string{secret}, strconv.FormatInt(now-600, 10), "v1=" + SignPayload(secret, now-600, body), body, ErrStaleSignature},
+		{"future timestamp", []string{secret}, strconv.FormatInt(now+600, 10), "v1=" + SignPayload(secret, now+600, body), body, ErrStaleSignature},
+		{"just inside tolerance", []string{secret}, strconv.FormatInt(now-240, 10), "v1=" + SignPayload(secret, now-240, body), body, nil},
+		{"missing timestamp", []string{secret}, "", valid, body, ErrMissingSignature},
+		{"missing signature", []string{secret}, stamp, "", body, ErrMissingSignature},
+		{"timestamp not a number", []string{secret}, "yesterday", valid, body, ErrInvalidSignature},
+		{"no version", []string{secret}, stamp, SignPayload(secret, now, body), body, ErrInvalidSignature},
+		{"unknown version", []string{secret}, stamp, "v0=" + SignPayload(secret, now, body), body, ErrInvalidSignature},
+		{"not hex", []string{secret}, stamp, "v1=zz", body, ErrInvalidSignature},
+		{"several entries, last matches", []string{secret}, stamp, "v1=" + SignPayload("whsec_other", now, body) + ", " + valid, body, nil},
+		{"several entries, first matches", []string{secret}, stamp, valid + ",v1=" + SignPayload("whsec_other", now, body), body, nil},
+		{"several entries, junk among them", []string{secret}, stamp, "v0=abc,v1=zz,garbage," + valid, body, nil},
+		{"several entries, none match", []string{secret}, stamp, "v1=" + SignPayload("a", now, body) + ",v1=" + SignPayload("b", now, body), body, ErrInvalidSignature},
+		{"signed with the rotated secret", []string{secret, oldSecret}, stamp, "v1=" + SignPayload(oldSecret, now, body), body, nil},
+		{"signed with both while rotating", []string{secret, oldSecret}, stamp, "v1=" + SignPayload(oldSecret, now, body) + ",v1=" + SignPayload(secret, now, body), body, nil},
+		{"rotated secret dropped", []string{secret}, stamp, "v1=" + SignPayload(oldSecret, now, body), body, ErrInvalidSignature},
+		{"no secrets", nil, stamp, valid, body, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secrets, tt.timestamp, tt.signature, tt.body, tolerance)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	body := []byte("hello")
+
+	got := SignPayload("secret", 1700000000, body)
+	if len(got) != 64 {
+		t.Errorf("signature %q isn't hex SHA-256", got)
+	}
+	if SignPayload("secret", 1700000000, body) != got {
+		t.Error("signing isn't deterministic")
+	}
+	if SignPayload("secret", 1700000001, body) == got {
+		t.Error("timestamp isn't signed")
+	}
+	if SignPayload("other", 1700000000, body) == got {
+		t.Error("secret isn't used")
+	}
+	// "1700000000.hello" mustn't sign the same as "170000000.0hello"
+	if SignPayload("secret", 170000000, []byte("0hello")) == got {
+		t.Error("timestamp and body run together")
+	}
+}
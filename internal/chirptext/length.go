@@ -0,0 +1,75 @@
+package chirptext
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// URLLength is what every link counts for, however long it really is.
+const URLLength = 23
+
+// MaxRunesPerCharacter caps the code points a chirp may hold per character of
+// its limit. Length counts a link as URLLength and a grapheme cluster as one
+// whatever they contain, so without a cap a short chirp could still be
+// megabytes of link or stacked combining marks. 16 leaves room for the
+// longest emoji sequences, a family with skin tones is 11.
+const MaxRunesPerCharacter = 16
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// Length counts a chirp the way a reader sees it: one per grapheme cluster, so
+// an emoji with skin tone or a flag is a single character, and every URL
+// counts as URLLength.
+func Length(body string) int {
+	length := 0
+	last := 0
+	for _, match := range urlSpans(body) {
+		length += uniseg.GraphemeClusterCount(body[last:match[0]]) + URLLength
+		last = match[1]
+	}
+
+	return length + uniseg.GraphemeClusterCount(body[last:])
+}
+
+// TooLong reports whether body is over maxLength characters as Length counts
+// them, or holds more than MaxRunesPerCharacter code points per character.
+func TooLong(body string, maxLength int) bool {
+	return utf8.RuneCountInString(body) > maxLength*MaxRunesPerCharacter || Length(body) > maxLength
+}
+
+// URLs returns the links in a chirp in the order they appear, without the
+// punctuation that usually trails a link at the end of a sentence.
+func URLs(body string) []string {
+	spans := urlSpans(body)
+	urls := make([]string, 0, len(spans))
+	for _, span := range spans {
+		urls = append(urls, body[span[0]:span[1]])
+	}
+
+	return urls
+}
+
+// urlSpans finds the links in body as start and end offsets. Punctuation
+// that ends a sentence or closes a bracket around the link is left out, so
+// "(see https://a.io/x)." links to https://a.io/x; a closing parenthesis the
+// link opened itself, as in Wikipedia URLs, stays.
+func urlSpans(body string) [][]int {
+	spans := urlPattern.FindAllStringIndex(body, -1)
+	for _, span := range spans {
+		for span[1] > span[0] {
+			last := body[span[1]-1]
+			if last == ')' && strings.Count(body[span[0]:span[1]], "(") >= strings.Count(body[span[0]:span[1]], ")") {
+				break
+			}
+			if !strings.ContainsRune(".,;:!?)]'\"", rune(last)) {
+				break
+			}
+			span[1]--
+		}
+	}
+
+	return spans
+}
@@ -0,0 +1,94 @@
+package chirptext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello world", 11},
+		{"short url", "see http://a.io", 4 + URLLength},
+		{"long url", "see https://example.com/" + strings.Repeat("a", 5000), 4 + URLLength},
+		{"two urls", "http://a.io and https://b.io/x", URLLength + 5 + URLLength},
+		{"url then text", "https://example.com/x, right?", URLLength + 8},
+		{"url ends a sentence", "see https://example.com/x.", 4 + URLLength + 1},
+		{"url in parentheses", "(https://example.com/x)", 1 + URLLength + 1},
+		{"url with its own parentheses", "https://en.wikipedia.org/wiki/Go_(game)", URLLength},
+		{"url with its own parentheses, in parentheses", "(https://en.wikipedia.org/wiki/Go_(game))", 1 + URLLength + 1},
+		{"url then several marks", "https://example.com/x?!", URLLength + 2},
+		{"precomposed accent", "caf\u00e9", 4},
+		{"combining accent", "cafe\u0301", 4},
+		{"stacked combining marks", "z" + strings.Repeat("\u0337\u0316\u0301", 100), 1},
+		{"skin tone", "\U0001F44B\U0001F3FD", 1},
+		{"flag", "\U0001F1F3\U0001F1F1", 1},
+		{"zwj family", "\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466", 1},
+		{"zwj family with skin tones", "\U0001F468\U0001F3FB\u200d\U0001F469\U0001F3FB\u200d\U0001F467\U0001F3FB\u200d\U0001F466\U0001F3FB", 1},
+		{"text and emoji", "hi \U0001F44B\U0001F3FD!", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Length(tt.body)
+			if got != tt.want {
+				t.Errorf("Length(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	family := "\U0001F468\U0001F3FB\u200d\U0001F469\U0001F3FB\u200d\U0001F467\U0001F3FB\u200d\U0001F466\U0001F3FB"
+
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"at the limit", strings.Repeat("a", 140), false},
+		{"over the limit", strings.Repeat("a", 141), true},
+		{"reasonable url", "look https://example.com/" + strings.Repeat("a", 200), false},
+		{"megabyte url", "look https://example.com/" + strings.Repeat("a", 1<<20), true},
+		{"zalgo", "z" + strings.Repeat("\u0301", 10000), true},
+		{"emoji families up to the limit", strings.Repeat(family, 140), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TooLong(tt.body, 140)
+			if got != tt.want {
+				t.Errorf("TooLong(%.40q…, 140) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"none", "no links here", nil},
+		{"trailing period", "see https://example.com/x.", []string{"https://example.com/x"}},
+		{"trailing comma", "https://a.io, https://b.io", []string{"https://a.io", "https://b.io"}},
+		{"in parentheses", "(see https://example.com/x).", []string{"https://example.com/x"}},
+		{"own parentheses", "https://en.wikipedia.org/wiki/Go_(game).", []string{"https://en.wikipedia.org/wiki/Go_(game)"}},
+		{"query kept", "https://example.com/?q=1&r=2!", []string{"https://example.com/?q=1&r=2"}},
+		{"quoted", `"https://example.com/x"`, []string{"https://example.com/x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := URLs(tt.body)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || len(got) != len(tt.want) {
+				t.Errorf("URLs(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
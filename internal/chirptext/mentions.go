@@ -0,0 +1,23 @@
+package chirptext
+
+import "regexp"
+
+// mentionPattern matches "@" followed by an email address, the only handle
+// users have. The "@" has to start a word, so neither an address written out
+// plainly nor a path like https://example.com/@a@b.io is a mention.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@./])@([\w.%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})`)
+
+// Mentions returns the email addresses a chirp mentions, each once, in the
+// order they first appear.
+func Mentions(body string) []string {
+	seen := make(map[string]bool)
+	mentions := make([]string, 0)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			mentions = append(mentions, match[1])
+		}
+	}
+
+	return mentions
+}
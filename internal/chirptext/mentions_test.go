@@ -0,0 +1,37 @@
+package chirptext
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"none", "hello world", nil},
+		{"at the start", "@alice@example.com hi", []string{"alice@example.com"}},
+		{"in the middle", "hi @alice@example.com, how are you", []string{"alice@example.com"}},
+		{"ends a sentence", "thanks @alice@example.com.", []string{"alice@example.com"}},
+		{"in parentheses", "(cc @alice@example.com)", []string{"alice@example.com"}},
+		{"subdomain", "@bob.smith+chirpy@mail.example.co.uk!", []string{"bob.smith+chirpy@mail.example.co.uk"}},
+		{"several", "@alice@example.com @bob@example.org", []string{"alice@example.com", "bob@example.org"}},
+		{"repeated", "@alice@example.com and again @alice@example.com", []string{"alice@example.com"}},
+		{"plain address", "mail alice@example.com", nil},
+		{"inside a word", "foo@alice@example.com", nil},
+		{"in a url", "https://example.com/@alice@example.com", nil},
+		{"no domain", "@alice", nil},
+		{"no tld", "@alice@localhost", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Mentions(tt.body)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Mentions(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
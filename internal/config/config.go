@@ -0,0 +1,415 @@
+// Package config loads the server's settings. Each setting comes from, in
+// increasing order of precedence: its default, an optional YAML or TOML
+// file, the environment (including a .env file), and command-line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Config is every setting the server reads at startup.
+//
+// The conf tag names a setting: upper-cased it is the environment variable,
+// as is it is the key in a config file, and with dashes it is the flag. Other
+// tags give its default and how it's validated; secret settings are never
+// printed.
+type Config struct {
+	Host string `conf:"host" usage:"interface to listen on, all when empty"`
+	Port int    `conf:"port" default:"8081" min:"1" max:"65535" usage:"port to listen on"`
+
+	TLSCertFile       string        `conf:"tls_cert_file" usage:"PEM certificate (chain) to serve HTTPS with; plain HTTP when empty"`
+	TLSKeyFile        string        `conf:"tls_key_file" usage:"PEM private key for tls_cert_file"`
+	TLSClientCAFile   string        `conf:"tls_client_ca_file" usage:"PEM CAs for client certificates; when set, /admin/ requires one"`
+	TLSReloadInterval time.Duration `conf:"tls_reload_interval_seconds" default:"60" min:"1" usage:"how often the TLS files are checked for changes; SIGHUP reloads at once"`
+	HTTPRedirectPort  int           `conf:"http_redirect_port" default:"0" min:"0" max:"65535" usage:"port redirecting plain HTTP to HTTPS, off when 0"`
+
+	Platform string `conf:"platform" usage:"\"dev\" enables development-only endpoints"`
+	DBURL    string `conf:"db_url" required:"true" secret:"true" format:"postgres_url" usage:"Postgres connection URL or key=value string"`
+	Secret   string `conf:"secret" required:"true" secret:"true" minlen:"32" usage:"key JWTs are signed with"`
+
+	PolkaKey                string        `conf:"polka_key" secret:"true" usage:"ApiKey Polka webhooks authenticate with"`
+	PolkaWebhookSecrets     []string      `conf:"polka_webhook_secrets" secret:"true" usage:"comma separated signing secrets for Polka webhooks; replaces polka_key when set"`
+	PolkaSignatureTolerance time.Duration `conf:"polka_signature_tolerance_seconds" default:"300" min:"1" usage:"how old a signed Polka webhook may be"`
+
+	AdminAPIKey         string `conf:"admin_api_key" secret:"true" usage:"ApiKey for the admin API"`
+	AccountDeletionMode string `conf:"account_deletion_mode" default:"cascade" oneof:"cascade anonymize" usage:"what deleting an account does to its chirps"`
+	ChirpMaxLength      int    `conf:"chirp_max_length" default:"140" min:"1" usage:"maximum chirp length in characters"`
+	ChirpMaxLengthRed   int    `conf:"chirp_max_length_red" default:"280" min:"1" usage:"maximum chirp length for Chirpy Red members"`
+
+	LogLevel        string `conf:"log_level" default:"info" oneof:"debug info warn error" usage:"minimum level logged"`
+	TracingExporter string `conf:"tracing_exporter" default:"none" oneof:"none otlp stdout file" usage:"where trace spans go"`
+	TracingFile     string `conf:"tracing_file" usage:"file the \"file\" tracing exporter appends to"`
+	OTelServiceName string `conf:"otel_service_name" default:"chirpy" usage:"service name on trace spans"`
+
+	HTTPReadHeaderTimeout time.Duration `conf:"http_read_header_timeout_seconds" default:"5" min:"1"`
+	HTTPReadTimeout       time.Duration `conf:"http_read_timeout_seconds" default:"30" min:"1"`
+	HTTPWriteTimeout      time.Duration `conf:"http_write_timeout_seconds" default:"60" min:"1"`
+	HTTPIdleTimeout       time.Duration `conf:"http_idle_timeout_seconds" default:"120" min:"1"`
+	HTTPMaxHeaderBytes    int           `conf:"http_max_header_bytes" default:"65536" min:"4096"`
+
+	ShutdownDrainDelay time.Duration `conf:"shutdown_drain_delay_seconds" default:"0" min:"0" usage:"how long readyz fails before the server stops accepting connections"`
+	ShutdownTimeout    time.Duration `conf:"shutdown_timeout_seconds" default:"30" min:"1" usage:"how long in-flight requests and workers get to finish"`
+}
+
+// Addr is the address to listen on.
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// TLS reports whether the server serves HTTPS.
+func (c *Config) TLS() bool {
+	return c.TLSCertFile != ""
+}
+
+// RedirectAddr is the address of the HTTP to HTTPS redirect listener.
+func (c *Config) RedirectAddr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.HTTPRedirectPort))
+}
+
+// Options say where Load looks besides the defaults.
+type Options struct {
+	// Args are the command-line arguments, without the program name.
+	Args []string
+	// LookupEnv reads the environment; os.LookupEnv when nil.
+	LookupEnv func(string) (string, bool)
+	// DotEnv is loaded into the process environment first unless empty.
+	// A missing file is fine.
+	DotEnv string
+}
+
+// Result is what Load found besides the config itself.
+type Result struct {
+	// PrintConfig is set by -print-config: print the config and exit.
+	PrintConfig bool
+	// File is the config file that was read, if any.
+	File string
+}
+
+// Load builds the config and validates it. A -config flag or CONFIG_FILE
+// variable names a .yaml, .yml or .toml file; its keys are the conf names.
+// Errors from validation list every problem at once.
+func Load(opts Options) (*Config, Result, error) {
+	if opts.DotEnv != "" {
+		err := godotenv.Load(opts.DotEnv)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, Result{}, fmt.Errorf("reading %s: %w", opts.DotEnv, err)
+		}
+	}
+	lookupEnv := opts.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	cfg := &Config{}
+	fields := settings(cfg)
+
+	flags := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML or TOML config file (env CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	flagValues := map[string]*string{}
+	for _, field := range fields {
+		flagValues[field.name] = flags.String(strings.ReplaceAll(field.name, "_", "-"), "", field.usage())
+	}
+	err := flags.Parse(opts.Args)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	if flags.NArg() > 0 {
+		return nil, Result{}, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	flagsSet := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		flagsSet[strings.ReplaceAll(f.Name, "-", "_")] = true
+	})
+
+	result := Result{PrintConfig: *printConfig, File: *configFile}
+	if result.File == "" {
+		result.File, _ = lookupEnv("CONFIG_FILE")
+	}
+	fileValues := map[string]any{}
+	if result.File != "" {
+		fileValues, err = readFile(result.File)
+		if err != nil {
+			return nil, Result{}, err
+		}
+	}
+
+	var errs []error
+	for key := range fileValues {
+		if !slices.ContainsFunc(fields, func(field setting) bool { return field.name == key }) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", result.File, key))
+		}
+	}
+
+	for _, field := range fields {
+		if field.tag.Get("default") != "" {
+			errs = append(errs, field.set(field.tag.Get("default"), "default"))
+		}
+		if value, ok := fileValues[field.name]; ok {
+			errs = append(errs, field.setAny(value, result.File))
+		}
+		if value, ok := lookupEnv(field.envName()); ok && value != "" {
+			errs = append(errs, field.set(value, "$"+field.envName()))
+		}
+		if flagsSet[field.name] {
+			errs = append(errs, field.set(*flagValues[field.name], "-"+strings.ReplaceAll(field.name, "_", "-")))
+		}
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, Result{}, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, Result{}, err
+	}
+	return cfg, result, nil
+}
+
+// Validate checks the tag rules on every setting, then the rules that
+// involve more than one.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, field := range settings(c) {
+		errs = append(errs, field.validate())
+	}
+
+	if c.TracingExporter == "file" && c.TracingFile == "" {
+		errs = append(errs, errors.New("tracing_file must be set for the file tracing exporter"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if !c.TLS() && c.TLSClientCAFile != "" {
+		errs = append(errs, errors.New("tls_client_ca_file needs tls_cert_file and tls_key_file"))
+	}
+	if !c.TLS() && c.HTTPRedirectPort != 0 {
+		errs = append(errs, errors.New("http_redirect_port needs tls_cert_file and tls_key_file"))
+	}
+	if c.HTTPRedirectPort != 0 && c.HTTPRedirectPort == c.Port {
+		errs = append(errs, errors.New("http_redirect_port can't be the same as port"))
+	}
+	if c.ChirpMaxLengthRed < c.ChirpMaxLength {
+		errs = append(errs, errors.New("chirp_max_length_red can't be less than chirp_max_length"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes every setting as "name = value", with secrets redacted.
+func (c *Config) Print(w io.Writer) {
+	for _, field := range settings(c) {
+		fmt.Fprintf(w, "%s = %s\n", field.name, field.display())
+	}
+}
+
+// LogValue logs the config with secrets redacted.
+func (c *Config) LogValue() slog.Value {
+	fields := settings(c)
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.String(field.name, field.display()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config file %s: want a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// setting is one field of Config.
+type setting struct {
+	name  string
+	tag   reflect.StructTag
+	value reflect.Value
+}
+
+func settings(c *Config) []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	fields := make([]setting, 0, t.NumField())
+	for i := range t.NumField() {
+		fields = append(fields, setting{
+			name:  t.Field(i).Tag.Get("conf"),
+			tag:   t.Field(i).Tag,
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+func (s setting) envName() string {
+	return strings.ToUpper(s.name)
+}
+
+func (s setting) isSecret() bool {
+	return s.tag.Get("secret") == "true"
+}
+
+func (s setting) usage() string {
+	usage := s.tag.Get("usage")
+	if usage == "" {
+		usage = strings.ReplaceAll(s.name, "_", " ")
+	}
+	usage += fmt.Sprintf(" (env %s", s.envName())
+	if def := s.tag.Get("default"); def != "" {
+		usage += ", default " + def
+	}
+	return usage + ")"
+}
+
+// set parses a textual value: lists are comma separated and durations are
+// Go durations like "90s" or a bare number of seconds.
+func (s setting) set(raw, source string) error {
+	var err error
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case []string:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		s.value.Set(reflect.ValueOf(values))
+	case int:
+		var n int
+		n, err = strconv.Atoi(raw)
+		s.value.SetInt(int64(n))
+	case time.Duration:
+		var d time.Duration
+		d, err = parseDuration(raw)
+		s.value.SetInt(int64(d))
+	default:
+		err = fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s from %s: invalid value %q", s.name, source, raw)
+	}
+	return nil
+}
+
+// setAny takes a value decoded from a config file, where numbers and lists
+// come typed rather than as text.
+func (s setting) setAny(value any, source string) error {
+	switch v := value.(type) {
+	case []any:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = fmt.Sprint(part)
+		}
+		return s.set(strings.Join(parts, ","), source)
+	case map[string]any:
+		return fmt.Errorf("%s from %s: expected a single value", s.name, source)
+	}
+	return s.set(fmt.Sprint(value), source)
+}
+
+func parseDuration(raw string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(raw)
+	if err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+func (s setting) validate() error {
+	if s.tag.Get("required") == "true" && s.value.IsZero() {
+		return fmt.Errorf("%s is required (env %s)", s.name, s.envName())
+	}
+
+	var n int64
+	switch v := s.value.Interface().(type) {
+	case int:
+		n = int64(v)
+	case time.Duration:
+		n = int64(v / time.Second)
+	case string:
+		if v == "" {
+			return nil
+		}
+		if oneOf := s.tag.Get("oneof"); oneOf != "" && !slices.Contains(strings.Fields(oneOf), v) {
+			return fmt.Errorf("%s must be one of %s", s.name, strings.ReplaceAll(oneOf, " ", ", "))
+		}
+		if minLen, _ := strconv.Atoi(s.tag.Get("minlen")); len(v) < minLen {
+			return fmt.Errorf("%s must be at least %d characters", s.name, minLen)
+		}
+		if s.tag.Get("format") == "postgres_url" {
+			// parsed the way the driver will, so key=value strings and
+			// socket URLs like postgres:///chirpy?host=/run/postgresql pass;
+			// pq's error is left out because it can quote the password
+			if _, err := pq.NewConnector(v); err != nil {
+				return fmt.Errorf("%s must be a postgres:// URL or key=value connection string", s.name)
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	if lower, err := strconv.ParseInt(s.tag.Get("min"), 10, 64); err == nil && n < lower {
+		return fmt.Errorf("%s must be at least %d", s.name, lower)
+	}
+	if upper, err := strconv.ParseInt(s.tag.Get("max"), 10, 64); err == nil && n > upper {
+		return fmt.Errorf("%s must be at most %d", s.name, upper)
+	}
+	return nil
+}
+
+// display is the value as printed: secrets only show whether they're set.
+func (s setting) display() string {
+	if s.isSecret() {
+		if s.value.IsZero() {
+			return ""
+		}
+		return redacted
+	}
+
+	switch v := s.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(s.value.Interface())
+}
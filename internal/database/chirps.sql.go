@@ -7,29 +7,94 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const adjustRepostCounts = `-- name: AdjustRepostCounts :exec
+UPDATE chirps
+SET rechirp_count = rechirp_count + $1::int,
+    quote_count = quote_count + $2::int
+WHERE id = $3
+`
+
+type AdjustRepostCountsParams struct {
+	RechirpDelta int32
+	QuoteDelta   int32
+	ID           uuid.UUID
+}
+
+func (q *Queries) AdjustRepostCounts(ctx context.Context, arg AdjustRepostCountsParams) error {
+	_, err := q.db.ExecContext(ctx, adjustRepostCounts, arg.RechirpDelta, arg.QuoteDelta, arg.ID)
+	return err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, status, publish_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	Status    string
+	PublishAt sql.NullTime
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.Status, arg.PublishAt)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Kind,
+		&i.OriginalChirpID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.Status,
+		&i.PublishAt,
+	)
+	return i, err
+}
+
+const createRepost = `-- name: CreateRepost :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, kind, original_chirp_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at
+`
+
+type CreateRepostParams struct {
+	Body            string
+	UserID          uuid.UUID
+	Kind            string
+	OriginalChirpID uuid.NullUUID
+}
+
+func (q *Queries) CreateRepost(ctx context.Context, arg CreateRepostParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createRepost, arg.Body, arg.UserID, arg.Kind, arg.OriginalChirpID)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +102,12 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Kind,
+		&i.OriginalChirpID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.Status,
+		&i.PublishAt,
 	)
 	return i, err
 }
@@ -51,11 +122,22 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps
+WHERE status = 'published'
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = chirps.user_id AND blocked_id = $1::uuid)
+       OR (blocker_id = $1::uuid AND blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE muter_id = $1::uuid AND muted_id = chirps.user_id
+)
+ORDER BY created_at ASC
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) GetAllChirps(ctx context.Context, viewerID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirps, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +151,12 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
 		); err != nil {
 			return nil, err
 		}
@@ -84,11 +172,28 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getAllChirpsByUserId = `-- name: GetAllChirpsByUserId :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps
+WHERE user_id = $1
+AND status = 'published'
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = chirps.user_id AND blocked_id = $2::uuid)
+       OR (blocker_id = $2::uuid AND blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE muter_id = $2::uuid AND muted_id = chirps.user_id
+)
+ORDER BY created_at ASC
 `
 
-func (q *Queries) GetAllChirpsByUserId(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsByUserId, userID)
+type GetAllChirpsByUserIdParams struct {
+	UserID   uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetAllChirpsByUserId(ctx context.Context, arg GetAllChirpsByUserIdParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirpsByUserId, arg.UserID, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +207,12 @@ func (q *Queries) GetAllChirpsByUserId(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
 		); err != nil {
 			return nil, err
 		}
@@ -117,7 +228,7 @@ func (q *Queries) GetAllChirpsByUserId(ctx context.Context, userID uuid.UUID) ([
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps WHERE id = $1
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -129,6 +240,247 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Kind,
+		&i.OriginalChirpID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.Status,
+		&i.PublishAt,
+	)
+	return i, err
+}
+
+const getChirpsByIds = `-- name: GetChirpsByIds :many
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps WHERE id = ANY($1::uuid[]) AND status = 'published'
+`
+
+func (q *Queries) GetChirpsByIds(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIds, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByUserIdPage = `-- name: GetChirpsByUserIdPage :many
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps
+WHERE user_id = $1
+  AND (created_at, id) > ($2::timestamp, $3::uuid)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type GetChirpsByUserIdPageParams struct {
+	UserID         uuid.UUID
+	AfterCreatedAt time.Time
+	AfterID        uuid.UUID
+	PageSize       int32
+}
+
+func (q *Queries) GetChirpsByUserIdPage(ctx context.Context, arg GetChirpsByUserIdPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByUserIdPage, arg.UserID, arg.AfterCreatedAt, arg.AfterID, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnpublishedChirpsByUserId = `-- name: GetUnpublishedChirpsByUserId :many
+SELECT id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at FROM chirps WHERE user_id = $1 AND status <> 'published' ORDER BY publish_at ASC NULLS LAST, created_at ASC
+`
+
+func (q *Queries) GetUnpublishedChirpsByUserId(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getUnpublishedChirpsByUserId, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const notifyChirpStream = `-- name: NotifyChirpStream :exec
+SELECT pg_notify('chirp_stream', $1::text)
+`
+
+// NotifyChirpStream tells every server instance listening on chirp_stream
+// about a new chirp.
+func (q *Queries) NotifyChirpStream(ctx context.Context, payload string) error {
+	_, err := q.db.ExecContext(ctx, notifyChirpStream, payload)
+	return err
+}
+
+const publishChirp = `-- name: PublishChirp :one
+UPDATE chirps SET status = 'published', publish_at = NULL, created_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status <> 'published'
+RETURNING id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at
+`
+
+func (q *Queries) PublishChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, publishChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Kind,
+		&i.OriginalChirpID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.Status,
+		&i.PublishAt,
 	)
 	return i, err
 }
+
+const publishDueChirps = `-- name: PublishDueChirps :many
+UPDATE chirps SET status = 'published', created_at = publish_at, publish_at = NULL, updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE status = 'scheduled' AND publish_at <= NOW()
+    ORDER BY publish_at ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, body, user_id, kind, original_chirp_id, rechirp_count, quote_count, status, publish_at
+`
+
+// PublishDueChirps claims due chirps with SKIP LOCKED so that several server
+// instances can run the scheduler without publishing the same chirp twice.
+// The chirp takes its scheduled time as created_at so it sorts where it was
+// meant to appear, and like PublishChirp clears publish_at.
+func (q *Queries) PublishDueChirps(ctx context.Context, limit int32) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, publishDueChirps, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Kind,
+			&i.OriginalChirpID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.Status,
+			&i.PublishAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const releaseRepostCountsByUser = `-- name: ReleaseRepostCountsByUser :exec
+UPDATE chirps
+SET rechirp_count = chirps.rechirp_count - reposts.rechirps,
+    quote_count = chirps.quote_count - reposts.quotes
+FROM (
+    SELECT original_chirp_id,
+        COUNT(*) FILTER (WHERE kind = 'rechirp') AS rechirps,
+        COUNT(*) FILTER (WHERE kind = 'quote') AS quotes
+    FROM chirps
+    WHERE user_id = $1 AND original_chirp_id IS NOT NULL
+    GROUP BY original_chirp_id
+) AS reposts
+WHERE chirps.id = reposts.original_chirp_id
+`
+
+func (q *Queries) ReleaseRepostCountsByUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, releaseRepostCountsByUser, userID)
+	return err
+}
@@ -11,12 +11,119 @@ import (
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
+type Bookmark struct {
+	UserID       uuid.UUID
+	ChirpID      uuid.UUID
+	CollectionID uuid.NullUUID
+	CreatedAt    time.Time
+}
+
+type BookmarkCollection struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Body      string
 	UserID    uuid.UUID
+	Name      string
+}
+
+type Chirp struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Body            string
+	UserID          uuid.UUID
+	Kind            string
+	OriginalChirpID uuid.NullUUID
+	RechirpCount    int32
+	QuoteCount      int32
+	Status          string
+	PublishAt       sql.NullTime
+}
+
+type ChirpAttachment struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	ChirpID      uuid.UUID
+	ContentType  string
+	SizeBytes    int64
+	Width        int32
+	Height       int32
+	StorageKey   string
+	ThumbnailKey string
+}
+
+type DomainEvent struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	EventType     string
+	UserID        uuid.NullUUID
+	Payload       string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	DispatchedAt  sql.NullTime
+	AbandonedAt   sql.NullTime
+}
+
+type DomainEventDelivery struct {
+	EventID     uuid.UUID
+	Subscriber  string
+	DeliveredAt time.Time
+}
+
+type LinkPreview struct {
+	Url         string
+	FetchedAt   time.Time
+	Ok          bool
+	Title       string
+	Description string
+	ImageUrl    string
+	SiteName    string
+	Error       string
+}
+
+type Notification struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	RecipientID uuid.UUID
+	Type        string
+	ChirpID     uuid.UUID
+	ActorIds    []uuid.UUID
+	ReadAt      sql.NullTime
+}
+
+type NotificationPreference struct {
+	UserID  uuid.UUID
+	Type    string
+	Enabled bool
+}
+
+type PageHit struct {
+	Path string
+	Day  time.Time
+	Hits int64
+}
+
+type Poll struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	ChirpID   uuid.UUID
+	ClosesAt  time.Time
+}
+
+type PollOption struct {
+	ID       uuid.UUID
+	PollID   uuid.UUID
+	Position int32
+	Label    string
+}
+
+type PollVote struct {
+	PollID    uuid.UUID
+	UserID    uuid.UUID
+	OptionID  uuid.UUID
+	CreatedAt time.Time
 }
 
 type RefreshToken struct {
@@ -28,6 +135,27 @@ type RefreshToken struct {
 	RevokedAt sql.NullTime
 }
 
+type Subscription struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	CanceledAt         sql.NullTime
+}
+
+type SubscriptionEvent struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	SubscriptionID uuid.UUID
+	Event          string
+	Status         string
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+}
+
 type User struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
@@ -35,4 +163,62 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	AvatarKey      sql.NullString
+	DeletedAt      sql.NullTime
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserMute struct {
+	MuterID   uuid.UUID
+	MutedID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	SubscriptionID uuid.UUID
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	DeliveredAt    sql.NullTime
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          uuid.UUID
+	DeliveryID  uuid.UUID
+	AttemptedAt time.Time
+	DurationMs  int32
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID
+	ReceivedAt  time.Time
+	Provider    string
+	EventID     string
+	EventType   string
+	Payload     string
+	Status      string
+	Attempts    int32
+	LastError   sql.NullString
+	ProcessedAt sql.NullTime
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.NullUUID
+	Url        string
+	Secret     string
+	EventTypes []string
 }
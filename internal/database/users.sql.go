@@ -7,10 +7,39 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const anonymizeUser = `-- name: AnonymizeUser :one
+UPDATE users
+SET email = 'deleted-' || id || '@deleted.invalid',
+    hashed_password = 'unset',
+    is_chirpy_red = false,
+    avatar_key = NULL,
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at
+`
+
+func (q *Queries) AnonymizeUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, anonymizeUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
@@ -20,7 +49,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at
 `
 
 type CreateUserParams struct {
@@ -38,6 +67,8 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -51,8 +82,17 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE id = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
@@ -65,12 +105,14 @@ func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at FROM users WHERE email = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -83,13 +125,41 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const updateUserAvatar = `-- name: UpdateUserAvatar :one
+UPDATE users SET avatar_key = $1, updated_at = NOW() WHERE id = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at
+`
+
+type UpdateUserAvatarParams struct {
+	AvatarKey sql.NullString
+	ID        uuid.UUID
+}
+
+func (q *Queries) UpdateUserAvatar(ctx context.Context, arg UpdateUserAvatarParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserAvatar, arg.AvatarKey, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const updateUserChirpyRed = `-- name: UpdateUserChirpyRed :one
 UPDATE users SET is_chirpy_red = $1, updated_at = NOW() WHERE id = $2
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at
 `
 
 type UpdateUserChirpyRedParams struct {
@@ -107,13 +177,15 @@ func (q *Queries) UpdateUserChirpyRed(ctx context.Context, arg UpdateUserChirpyR
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const updateUserEmailAndPassword = `-- name: UpdateUserEmailAndPassword :one
-UPDATE users SET email = $1, hashed_password = $2, updated_at = NOW() WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+UPDATE users SET email = $1, hashed_password = $2, updated_at = NOW() WHERE id = $3 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, avatar_key, deleted_at
 `
 
 type UpdateUserEmailAndPasswordParams struct {
@@ -132,6 +204,8 @@ func (q *Queries) UpdateUserEmailAndPassword(ctx context.Context, arg UpdateUser
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.AvatarKey,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -0,0 +1,183 @@
+// Package events dispatches domain events from a transactional outbox to
+// in-process subscribers.
+//
+// Events are written to the outbox in the same database transaction as the
+// change they describe, so an event exists exactly when the change was
+// committed. The bus then hands each event to every subscriber at least
+// once: a subscriber that fails is retried with backoff, up to maxAttempts
+// times before the event is abandoned, and one that already succeeded isn't called again for the same event unless the
+// process dies between the call and recording it. Subscribers therefore
+// have to tolerate the odd repeat.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	claimBatchSize = 100
+	maxRetryDelay  = 10 * time.Minute
+	// with the backoff in retry this allows roughly three hours of tries
+	maxAttempts   = 25
+	pruneInterval = time.Hour
+	// dispatched events are kept around this long for debugging
+	retention = 7 * 24 * time.Hour
+)
+
+type Event struct {
+	ID        uuid.UUID
+	Type      string
+	CreatedAt time.Time
+	// the user the event is about, if any
+	UserID  uuid.NullUUID
+	Payload json.RawMessage
+	// failed dispatches so far
+	Attempts int
+}
+
+type Handler func(ctx context.Context, event Event) error
+
+// Store is the outbox the bus reads from.
+type Store interface {
+	// ClaimDue leases up to limit due events so that other instances skip
+	// them for a while.
+	ClaimDue(ctx context.Context, limit int) ([]Event, error)
+	IsDelivered(ctx context.Context, eventID uuid.UUID, subscriber string) (bool, error)
+	MarkDelivered(ctx context.Context, eventID uuid.UUID, subscriber string) error
+	// Complete is called once every subscriber has the event.
+	Complete(ctx context.Context, eventID uuid.UUID) error
+	// Retry counts a failed attempt and puts the event back for another
+	// try at next.
+	Retry(ctx context.Context, eventID uuid.UUID, next time.Time, reason string) error
+	// Abandon counts a failed attempt and stops dispatching the event.
+	Abandon(ctx context.Context, eventID uuid.UUID, reason string) error
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+type Bus struct {
+	store       Store
+	subscribers map[string][]subscriber
+}
+
+func NewBus(store Store) *Bus {
+	return &Bus{
+		store:       store,
+		subscribers: make(map[string][]subscriber),
+	}
+}
+
+// Subscribe registers handler for the given event types. name identifies
+// the subscriber in the outbox and must stay the same across releases, or
+// events in flight are delivered to it again. All subscriptions have to be
+// made before Run.
+func (b *Bus) Subscribe(name string, handler Handler, eventTypes ...string) {
+	for _, eventType := range eventTypes {
+		b.subscribers[eventType] = append(b.subscribers[eventType], subscriber{name: name, handler: handler})
+	}
+}
+
+// Run dispatches due events every interval until ctx is done.
+func (b *Bus) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastPrune := time.Time{}
+	for {
+		b.dispatchDue(ctx)
+
+		if time.Since(lastPrune) > pruneInterval {
+			err := b.store.Prune(ctx, time.Now().UTC().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				slog.Error("Error pruning domain events", "error", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bus) dispatchDue(ctx context.Context) {
+	for {
+		events, err := b.store.ClaimDue(ctx, claimBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error claiming domain events", "error", err)
+			}
+			return
+		}
+
+		// in order, so subscribers see events roughly as they happened
+		for _, event := range events {
+			if ctx.Err() != nil {
+				return
+			}
+			b.dispatch(ctx, event)
+		}
+
+		if len(events) < claimBatchSize {
+			return
+		}
+	}
+}
+
+func (b *Bus) dispatch(ctx context.Context, event Event) {
+	for _, sub := range b.subscribers[event.Type] {
+		delivered, err := b.store.IsDelivered(ctx, event.ID, sub.name)
+		if err == nil && !delivered {
+			err = sub.handler(ctx, event)
+			if err == nil {
+				err = b.store.MarkDelivered(ctx, event.ID, sub.name)
+			}
+		}
+		if err != nil {
+			b.retry(ctx, event, sub.name, err)
+			return
+		}
+	}
+
+	err := b.store.Complete(ctx, event.ID)
+	if err != nil {
+		slog.Error("Error completing domain event", "event_id", event.ID, "error", err)
+	}
+}
+
+func (b *Bus) retry(ctx context.Context, event Event, subscriber string, cause error) {
+	if ctx.Err() != nil {
+		// shutting down; the lease runs out and the event comes back
+		return
+	}
+
+	slog.Error("Error delivering domain event", "event_type", event.Type, "event_id", event.ID, "subscriber", subscriber, "error", cause)
+
+	// a poison event would otherwise come back every maxRetryDelay forever
+	if event.Attempts+1 >= maxAttempts {
+		slog.Error("Giving up on domain event", "event_type", event.Type, "event_id", event.ID, "attempts", event.Attempts+1)
+		err := b.store.Abandon(ctx, event.ID, subscriber+": "+cause.Error())
+		if err != nil {
+			slog.Error("Error abandoning domain event", "event_id", event.ID, "error", err)
+		}
+		return
+	}
+
+	delay := min(time.Second<<min(event.Attempts+1, 20), maxRetryDelay)
+
+	err := b.store.Retry(ctx, event.ID, time.Now().UTC().Add(delay), subscriber+": "+cause.Error())
+	if err != nil {
+		slog.Error("Error rescheduling domain event", "event_id", event.ID, "error", err)
+	}
+}
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeStore is an in-memory outbox that records what the bus did with it.
+type fakeStore struct {
+	mu        sync.Mutex
+	due       []Event
+	delivered map[string]bool
+	completed []uuid.UUID
+	retried   map[uuid.UUID]string
+	abandoned map[uuid.UUID]string
+	retryAt   time.Time
+}
+
+func newFakeStore(due ...Event) *fakeStore {
+	return &fakeStore{
+		due:       due,
+		delivered: map[string]bool{},
+		retried:   map[uuid.UUID]string{},
+		abandoned: map[uuid.UUID]string{},
+	}
+}
+
+func (s *fakeStore) ClaimDue(_ context.Context, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, len(s.due))
+	claimed := s.due[:n]
+	s.due = s.due[n:]
+	return claimed, nil
+}
+
+func (s *fakeStore) IsDelivered(_ context.Context, eventID uuid.UUID, subscriber string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delivered[eventID.String()+"/"+subscriber], nil
+}
+
+func (s *fakeStore) MarkDelivered(_ context.Context, eventID uuid.UUID, subscriber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[eventID.String()+"/"+subscriber] = true
+	return nil
+}
+
+func (s *fakeStore) Complete(_ context.Context, eventID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completed = append(s.completed, eventID)
+	return nil
+}
+
+func (s *fakeStore) Retry(_ context.Context, eventID uuid.UUID, next time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retried[eventID] = reason
+	s.retryAt = next
+	return nil
+}
+
+func (s *fakeStore) Abandon(_ context.Context, eventID uuid.UUID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandoned[eventID] = reason
+	return nil
+}
+
+func (s *fakeStore) Prune(context.Context, time.Time) error { return nil }
+
+// recorder is a handler that notes its calls and fails while err is set.
+type recorder struct {
+	calls []uuid.UUID
+	err   error
+}
+
+func (r *recorder) handle(_ context.Context, event Event) error {
+	r.calls = append(r.calls, event.ID)
+	return r.err
+}
+
+func TestDispatchDeliversToEverySubscriber(t *testing.T) {
+	event := Event{ID: uuid.New(), Type: "chirp.created"}
+	store := newFakeStore(event)
+	first, second, other := &recorder{}, &recorder{}, &recorder{}
+
+	bus := NewBus(store)
+	bus.Subscribe("first", first.handle, "chirp.created")
+	bus.Subscribe("second", second.handle, "chirp.created", "user.upgraded")
+	bus.Subscribe("other", other.handle, "user.upgraded")
+	bus.dispatchDue(context.Background())
+
+	if len(first.calls) != 1 || len(second.calls) != 1 || len(other.calls) != 0 {
+		t.Errorf("calls: first %d, second %d, other %d", len(first.calls), len(second.calls), len(other.calls))
+	}
+	if !store.delivered[event.ID.String()+"/first"] || !store.delivered[event.ID.String()+"/second"] {
+		t.Errorf("delivered = %v", store.delivered)
+	}
+	if !slices.Equal(store.completed, []uuid.UUID{event.ID}) || len(store.retried) != 0 {
+		t.Errorf("completed %v, retried %v", store.completed, store.retried)
+	}
+}
+
+func TestDispatchRetriesFailedSubscriber(t *testing.T) {
+	event := Event{ID: uuid.New(), Type: "chirp.created", Attempts: 2}
+	store := newFakeStore(event)
+	first, failing, last := &recorder{}, &recorder{err: errors.New("preview queue full")}, &recorder{}
+
+	bus := NewBus(store)
+	bus.Subscribe("first", first.handle, "chirp.created")
+	bus.Subscribe("failing", failing.handle, "chirp.created")
+	bus.Subscribe("last", last.handle, "chirp.created")
+	before := time.Now().UTC()
+	bus.dispatchDue(context.Background())
+
+	if len(first.calls) != 1 || len(failing.calls) != 1 {
+		t.Errorf("calls: first %d, failing %d", len(first.calls), len(failing.calls))
+	}
+	// the failure stops the rest until the retry
+	if len(last.calls) != 0 {
+		t.Error("subscriber after the failing one was called")
+	}
+	if len(store.completed) != 0 {
+		t.Error("event completed")
+	}
+	if !strings.HasPrefix(store.retried[event.ID], "failing: ") {
+		t.Errorf("retry reason = %q", store.retried[event.ID])
+	}
+	// third failure: 2^3 seconds
+	if delay := store.retryAt.Sub(before); delay < 8*time.Second || delay > 9*time.Second {
+		t.Errorf("retried in %v", delay)
+	}
+
+	// the retry skips the subscriber that already has the event
+	failing.err = nil
+	store.due = []Event{{ID: event.ID, Type: event.Type, Attempts: 3}}
+	bus.dispatchDue(context.Background())
+
+	if len(first.calls) != 1 {
+		t.Errorf("first called %d times", len(first.calls))
+	}
+	if len(failing.calls) != 2 || len(last.calls) != 1 {
+		t.Errorf("calls: failing %d, last %d", len(failing.calls), len(last.calls))
+	}
+	if !slices.Equal(store.completed, []uuid.UUID{event.ID}) {
+		t.Errorf("completed = %v", store.completed)
+	}
+}
+
+func TestDispatchAbandonsPoisonEvent(t *testing.T) {
+	tests := []struct {
+		attempts  int
+		abandoned bool
+	}{
+		{0, false},
+		{maxAttempts - 2, false},
+		{maxAttempts - 1, true},
+		{maxAttempts + 10, true},
+	}
+
+	for _, tt := range tests {
+		event := Event{ID: uuid.New(), Type: "chirp.created", Attempts: tt.attempts}
+		store := newFakeStore(event)
+		failing := &recorder{err: errors.New("bad payload")}
+
+		bus := NewBus(store)
+		bus.Subscribe("failing", failing.handle, "chirp.created")
+		bus.dispatchDue(context.Background())
+
+		_, abandoned := store.abandoned[event.ID]
+		_, retried := store.retried[event.ID]
+		if abandoned != tt.abandoned || retried == tt.abandoned {
+			t.Errorf("after %d attempts: abandoned %v, retried %v", tt.attempts, abandoned, retried)
+		}
+		if len(store.completed) != 0 {
+			t.Errorf("after %d attempts: completed", tt.attempts)
+		}
+	}
+}
+
+// Shutting down isn't the subscriber's fault, so it doesn't count as an
+// attempt; the lease runs out and the event comes back.
+func TestDispatchCanceled(t *testing.T) {
+	event := Event{ID: uuid.New(), Type: "chirp.created"}
+	store := newFakeStore(event)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	bus := NewBus(store)
+	bus.Subscribe("slow", func(ctx context.Context, event Event) error {
+		cancel()
+		return ctx.Err()
+	}, "chirp.created")
+	bus.dispatchDue(ctx)
+
+	if len(store.retried) != 0 || len(store.abandoned) != 0 || len(store.completed) != 0 {
+		t.Errorf("retried %v, abandoned %v, completed %v", store.retried, store.abandoned, store.completed)
+	}
+}
@@ -0,0 +1,197 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/safehttp"
+	"golang.org/x/net/html"
+)
+
+const (
+	fetchTimeout = 5 * time.Second
+	maxBodyBytes = 512 << 10
+	maxRedirects = 3
+	maxTextRunes = 300
+)
+
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Fetcher downloads pages and pulls their OpenGraph / Twitter card metadata.
+// Its client only ever connects to public addresses, see safehttp.
+type Fetcher struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+func NewFetcher() *Fetcher {
+	return newFetcher(safehttp.NewTransport(fetchTimeout), fetchTimeout)
+}
+
+// newFetcher takes the transport and the per-step timeout, so tests can
+// reach httptest servers and don't have to wait out fetchTimeout.
+func newFetcher(transport http.RoundTripper, timeout time.Duration) *Fetcher {
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   2 * timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return errors.New("unsupported redirect scheme")
+				}
+				return nil
+			},
+		},
+		timeout: timeout,
+	}
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Preview{}, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return Preview{}, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("User-Agent", "ChirpyBot/1.0 (+link previews)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return Preview{}, fmt.Errorf("unsupported content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	preview := parse(io.LimitReader(resp.Body, maxBodyBytes), resp.Request.URL)
+	preview.URL = rawURL
+	if preview.Title == "" && preview.Description == "" {
+		return Preview{}, errors.New("page has no preview metadata")
+	}
+
+	return preview, nil
+}
+
+// parse walks the document head collecting og:*, twitter:* and the plain
+// <title> / description as fallbacks. OpenGraph wins over Twitter cards,
+// which win over the plain tags.
+func parse(r io.Reader, base *url.URL) Preview {
+	meta := make(map[string]string)
+	var title string
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "title":
+				inTitle = tokenType == html.StartTagToken
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+				if key != "" && content != "" {
+					if _, ok := meta[key]; !ok {
+						meta[key] = content
+					}
+				}
+			case "body":
+				// all the metadata we care about lives in the head
+				return buildPreview(meta, title, base)
+			}
+		case html.EndTagToken:
+			if token.Data == "title" {
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(token.Data)
+			}
+		}
+	}
+
+	return buildPreview(meta, title, base)
+}
+
+func buildPreview(meta map[string]string, title string, base *url.URL) Preview {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := strings.TrimSpace(meta[key]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	preview := Preview{
+		Title:       truncate(first("og:title", "twitter:title"), maxTextRunes),
+		Description: truncate(first("og:description", "twitter:description", "description"), maxTextRunes),
+		SiteName:    truncate(first("og:site_name"), maxTextRunes),
+	}
+	if preview.Title == "" {
+		preview.Title = truncate(title, maxTextRunes)
+	}
+
+	if image := first("og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if imageURL, err := base.Parse(image); err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			preview.ImageURL = imageURL.String()
+		}
+	}
+
+	return preview
+}
+
+func truncate(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+
+	return string(runes[:maxRunes-1]) + "…"
+}
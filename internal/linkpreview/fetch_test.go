@@ -0,0 +1,219 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/safehttp"
+)
+
+// testFetcher returns a fetcher that may only connect to srv, standing in
+// for a public site.
+func testFetcher(t *testing.T, srv *httptest.Server, timeout time.Duration) *Fetcher {
+	t.Helper()
+	addr := netip.MustParseAddrPort(srv.Listener.Addr().String())
+	transport := safehttp.NewTransportWithCheck(timeout, func(dialed netip.AddrPort) bool {
+		return dialed == addr
+	})
+	t.Cleanup(transport.CloseIdleConnections)
+	return newFetcher(transport, timeout)
+}
+
+func servePage(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want Preview
+	}{
+		{
+			name: "opengraph",
+			page: `<html><head>
+				<meta property="og:title" content="OG title">
+				<meta property="og:description" content="OG description">
+				<meta property="og:site_name" content="Example">
+				<meta property="og:image" content="/img/card.png">
+				<title>Plain title</title>
+			</head><body></body></html>`,
+			want: Preview{Title: "OG title", Description: "OG description", SiteName: "Example", ImageURL: "/img/card.png"},
+		},
+		{
+			name: "opengraph wins over twitter",
+			page: `<head>
+				<meta name="twitter:title" content="Twitter title">
+				<meta property="og:title" content="OG title">
+				<meta name="twitter:image" content="https://cdn.example.com/t.png">
+			</head>`,
+			want: Preview{Title: "OG title", ImageURL: "https://cdn.example.com/t.png"},
+		},
+		{
+			name: "plain tags as fallback",
+			page: `<head><title>  Plain title </title><meta name="description" content="Plain description"></head>`,
+			want: Preview{Title: "Plain title", Description: "Plain description"},
+		},
+		{
+			name: "first value wins",
+			page: `<head><meta property="og:title" content="First"><meta property="og:title" content="Second"></head>`,
+			want: Preview{Title: "First"},
+		},
+		{
+			name: "metadata in the body is ignored",
+			page: `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want: Preview{Title: "Head"},
+		},
+		{
+			name: "non-http image dropped",
+			page: `<head><meta property="og:title" content="T"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: Preview{Title: "T"},
+		},
+		{
+			name: "long title truncated",
+			page: `<head><title>` + strings.Repeat("a", 400) + `</title></head>`,
+			want: Preview{Title: strings.Repeat("a", maxTextRunes-1) + "…"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(servePage(tt.page))
+			defer srv.Close()
+
+			got, err := testFetcher(t, srv, time.Second).Fetch(context.Background(), srv.URL+"/post")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.URL = srv.URL + "/post"
+			if strings.HasPrefix(tt.want.ImageURL, "/") {
+				tt.want.ImageURL = srv.URL + tt.want.ImageURL
+			}
+			if got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"no metadata", servePage(`<html><head></head><body>hi</body></html>`)},
+		{"not found", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "gone", 404)
+		}},
+		{"not html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"title":"nope"}`)
+		}},
+		// the title only comes after the size limit
+		{"too large", servePage(`<head><!--` + strings.Repeat("x", maxBodyBytes) + `--><title>Late</title></head>`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			_, err := testFetcher(t, srv, time.Second).Fetch(context.Background(), srv.URL)
+			if err == nil {
+				t.Error("Fetch succeeded")
+			}
+		})
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := testFetcher(t, srv, 100*time.Millisecond).Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("Fetch succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %s", elapsed)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/short":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+		case "/final":
+			servePage(`<head><title>Final</title><meta property="og:image" content="card.png"></head>`)(w, r)
+		}
+	}))
+	defer srv.Close()
+	fetcher := testFetcher(t, srv, time.Second)
+
+	got, err := fetcher.Fetch(context.Background(), srv.URL+"/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relative URLs resolve against where the redirect ended up
+	if got.Title != "Final" || got.ImageURL != srv.URL+"/card.png" || got.URL != srv.URL+"/short" {
+		t.Errorf("got %+v", got)
+	}
+
+	for _, path := range []string{"/loop", "/ftp"} {
+		_, err := fetcher.Fetch(context.Background(), srv.URL+path)
+		if err == nil {
+			t.Errorf("Fetch(%s) succeeded", path)
+		}
+	}
+}
+
+func TestFetchUnsupportedScheme(t *testing.T) {
+	_, err := NewFetcher().Fetch(context.Background(), "file:///etc/passwd")
+	if err == nil {
+		t.Error("Fetch succeeded")
+	}
+}
+
+// The default fetcher must refuse to connect to our own network, whether
+// the URL points there directly or redirects there.
+func TestFetchForbiddenAddresses(t *testing.T) {
+	internal := httptest.NewServer(servePage(`<head><title>Internal</title></head>`))
+	defer internal.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), internal.URL)
+	if !errors.Is(err, safehttp.ErrForbiddenAddress) {
+		t.Errorf("direct: err = %v, want %v", err, safehttp.ErrForbiddenAddress)
+	}
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer public.Close()
+
+	_, err = testFetcher(t, public, time.Second).Fetch(context.Background(), public.URL)
+	if !errors.Is(err, safehttp.ErrForbiddenAddress) {
+		t.Errorf("redirect: err = %v, want %v", err, safehttp.ErrForbiddenAddress)
+	}
+}
@@ -0,0 +1,115 @@
+package linkpreview
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Store is where the pool caches what it fetched. Failed fetches are saved
+// too so a broken link isn't retried on every chirp that mentions it.
+type Store interface {
+	IsFresh(ctx context.Context, url string) (bool, error)
+	Save(ctx context.Context, url string, preview Preview, fetchErr error) error
+}
+
+// Pool fetches previews in the background with a fixed number of workers.
+// Enqueue never blocks the request that triggered it: when the queue is full
+// the URL is dropped and will be picked up the next time it's chirped.
+type Pool struct {
+	fetcher *Fetcher
+	store   Store
+	jobs    chan string
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPool(fetcher *Fetcher, store Store, workers, queueSize int) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &Pool{
+		fetcher:  fetcher,
+		store:    store,
+		jobs:     make(chan string, queueSize),
+		inFlight: make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.work()
+	}
+
+	return pool
+}
+
+func (p *Pool) Enqueue(urls ...string) {
+	for _, url := range urls {
+		p.mu.Lock()
+		if p.inFlight[url] {
+			p.mu.Unlock()
+			continue
+		}
+		p.inFlight[url] = true
+		p.mu.Unlock()
+
+		select {
+		case p.jobs <- url:
+		default:
+			slog.Warn("Link preview queue is full, dropping URL", "url", url)
+			p.done(url)
+		}
+	}
+}
+
+// Close stops the workers, abandoning whatever is still queued.
+func (p *Pool) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case url := <-p.jobs:
+			p.process(url)
+			p.done(url)
+		}
+	}
+}
+
+func (p *Pool) process(url string) {
+	fresh, err := p.store.IsFresh(p.ctx, url)
+	if err != nil {
+		slog.Error("Error checking link preview cache", "url", url, "error", err)
+		return
+	}
+	if fresh {
+		return
+	}
+
+	preview, fetchErr := p.fetcher.Fetch(p.ctx, url)
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	err = p.store.Save(p.ctx, url, preview, fetchErr)
+	if err != nil {
+		slog.Error("Error saving link preview", "url", url, "error", err)
+	}
+}
+
+func (p *Pool) done(url string) {
+	p.mu.Lock()
+	delete(p.inFlight, url)
+	p.mu.Unlock()
+}
@@ -0,0 +1,194 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+var allowedContentTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+}
+
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MinWidth  int
+	MinHeight int
+}
+
+type Image struct {
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+	Data        []byte
+	decoded     image.Image
+}
+
+// Load sniffs the content type from the bytes themselves (the multipart header
+// is client controlled), checks the dimensions before decoding the full image
+// and returns the decoded result. Data is encoded again from the pixels, so
+// EXIF (GPS position, camera serial numbers) and other metadata the upload
+// carried is dropped.
+func Load(r io.Reader, limits Limits) (Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Image{}, err
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedContentTypes[contentType]
+	if !ok {
+		return Image{}, fmt.Errorf("unsupported content type %s", contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image")
+	}
+
+	if config.Width < limits.MinWidth || config.Height < limits.MinHeight {
+		return Image{}, fmt.Errorf("image is too small")
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight {
+		return Image{}, fmt.Errorf("image is too large")
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image")
+	}
+
+	data, err = reencode(contentType, data, decoded)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image")
+	}
+
+	return Image{
+		ContentType: contentType,
+		Extension:   ext,
+		Width:       config.Width,
+		Height:      config.Height,
+		Data:        data,
+		decoded:     decoded,
+	}, nil
+}
+
+// reencode writes the image out again with nothing but its pixels. GIFs go
+// through DecodeAll so animations keep every frame.
+func reencode(contentType string, data []byte, decoded image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err := jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, err
+		}
+	case "image/png":
+		err := png.Encode(&buf, decoded)
+		if err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		frames, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		err = gif.EncodeAll(&buf, frames)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", contentType)
+	}
+	return buf.Bytes(), nil
+}
+
+// Thumbnail scales the image down to fit in a maxSize square, keeping the
+// aspect ratio, and encodes it as PNG (or JPEG for JPEG sources).
+func (img Image) Thumbnail(maxSize int) (Image, error) {
+	width, height := img.Width, img.Height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = max(1, height*maxSize/width)
+			width = maxSize
+		} else {
+			width = max(1, width*maxSize/height)
+			height = maxSize
+		}
+	}
+
+	scaled := scale(img.decoded, width, height)
+
+	var buf bytes.Buffer
+	var err error
+	if img.ContentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	contentType := "image/png"
+	if img.ContentType == "image/jpeg" {
+		contentType = "image/jpeg"
+	}
+
+	return Image{
+		ContentType: contentType,
+		Extension:   allowedContentTypes[contentType],
+		Width:       width,
+		Height:      height,
+		Data:        buf.Bytes(),
+		decoded:     scaled,
+	}, nil
+}
+
+// scale does a box filter downscale: every destination pixel is the average of
+// the source pixels it covers.
+func scale(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,220 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var limits = Limits{MinWidth: 2, MinHeight: 2, MaxWidth: 100, MaxHeight: 100}
+
+// marker stands in for the GPS position a phone writes into a photo.
+const marker = "GPS 52.5200N 13.4050E"
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x * 10), uint8(y * 10), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, testImage(width, height), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := png.Encode(&buf, testImage(width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	animation := &gif.GIF{}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		frame.SetColorIndex(0, 0, uint8(i))
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, animation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF puts an APP1 EXIF segment right after the JPEG's SOI marker.
+func withEXIF(data []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), marker...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// withTextChunk puts a tEXt chunk right after the PNG's IHDR chunk.
+func withTextChunk(data []byte) []byte {
+	// 8 byte signature, then IHDR: 4 length, 4 type, 13 data, 4 CRC
+	const afterIHDR = 8 + 4 + 4 + 13 + 4
+	body := append([]byte("tEXtComment\x00"), marker...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)-4))
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+
+	out := append([]byte{}, data[:afterIHDR]...)
+	out = append(out, chunk...)
+	return append(out, data[afterIHDR:]...)
+}
+
+// withComment puts a comment extension right before the GIF's trailer.
+func withComment(data []byte) []byte {
+	extension := []byte{0x21, 0xFE, byte(len(marker))}
+	extension = append(extension, marker...)
+	extension = append(extension, 0)
+
+	out := append([]byte{}, data[:len(data)-1]...)
+	out = append(out, extension...)
+	return append(out, data[len(data)-1])
+}
+
+func TestLoadDropsMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"jpeg exif", withEXIF(encodeJPEG(t, 8, 6)), "image/jpeg"},
+		{"png text chunk", withTextChunk(encodePNG(t, 8, 6)), "image/png"},
+		{"gif comment", withComment(encodeGIF(t, 8, 6, 1)), "image/gif"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Contains(tt.data, []byte(marker)) {
+				t.Fatal("test image doesn't carry the marker")
+			}
+
+			img, err := Load(bytes.NewReader(tt.data), limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(img.Data, []byte(marker)) {
+				t.Error("metadata survived")
+			}
+			if img.ContentType != tt.contentType || img.Width != 8 || img.Height != 6 {
+				t.Errorf("got %s %dx%d", img.ContentType, img.Width, img.Height)
+			}
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatalf("stored data doesn't decode: %v", err)
+			}
+			if config.Width != 8 || config.Height != 6 {
+				t.Errorf("stored image is %dx%d", config.Width, config.Height)
+			}
+		})
+	}
+}
+
+func TestLoadKeepsAnimation(t *testing.T) {
+	img, err := Load(bytes.NewReader(encodeGIF(t, 4, 4, 3)), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	animation, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 3 {
+		t.Errorf("got %d frames, want 3", len(animation.Image))
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	pngData := encodePNG(t, 8, 6)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not an image", []byte("<html><body>hi</body></html>")},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)},
+		{"truncated", pngData[:len(pngData)/2]},
+		{"too small", encodePNG(t, 1, 1)},
+		{"too wide", encodePNG(t, 101, 2)},
+		{"too tall", encodeJPEG(t, 2, 101)},
+		{"empty", nil},
+	}
+
+	for _, tt := range tests {
+		_, err := Load(bytes.NewReader(tt.data), limits)
+		if err == nil {
+			t.Errorf("%s: loaded", tt.name)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name                     string
+		data                     []byte
+		maxSize                  int
+		width, height            int
+		contentType, wantContent string
+	}{
+		{"wide", encodePNG(t, 80, 40), 20, 20, 10, "image/png", "image/png"},
+		{"tall", encodeJPEG(t, 30, 90), 45, 15, 45, "image/jpeg", "image/jpeg"},
+		{"already small", encodePNG(t, 10, 5), 20, 10, 5, "image/png", "image/png"},
+		{"thin", encodePNG(t, 100, 2), 10, 10, 1, "image/png", "image/png"},
+		{"gif becomes png", encodeGIF(t, 40, 40, 1), 20, 20, 20, "image/gif", "image/png"},
+	}
+
+	for _, tt := range tests {
+		img, err := Load(bytes.NewReader(tt.data), limits)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if img.ContentType != tt.contentType {
+			t.Fatalf("%s: loaded as %s", tt.name, img.ContentType)
+		}
+
+		thumbnail, err := img.Thumbnail(tt.maxSize)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if thumbnail.Width != tt.width || thumbnail.Height != tt.height || thumbnail.ContentType != tt.wantContent {
+			t.Errorf("%s: got %s %dx%d, want %s %dx%d", tt.name, thumbnail.ContentType, thumbnail.Width, thumbnail.Height, tt.wantContent, tt.width, tt.height)
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(thumbnail.Data))
+		if err != nil || config.Width != tt.width || config.Height != tt.height || "image/"+format != tt.wantContent {
+			t.Errorf("%s: encoded thumbnail is %s %dx%d (%v)", tt.name, format, config.Width, config.Height, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// DBBuckets suit database query latencies, in seconds.
+var DBBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// DBTX matches the interface the sqlc generated queries run against, so an
+// instrumented connection can be handed to database.New.
+type DBTX interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+// DB times every query run through it, labeled with the sqlc query name.
+type DB struct {
+	db        DBTX
+	durations *HistogramVec
+}
+
+// InstrumentDB wraps db so each query is observed in durations, which must
+// take the labels "query" and "outcome".
+func InstrumentDB(db DBTX, durations *HistogramVec) *DB {
+	return &DB{db: db, durations: durations}
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := d.db.ExecContext(ctx, query, args...)
+	d.observe(query, start, err)
+	return result, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return d.db.PrepareContext(ctx, query)
+}
+
+// QueryContext only covers the time to the first row, reading the rest
+// happens after it returns.
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	d.observe(query, start, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := d.db.QueryRowContext(ctx, query, args...)
+	d.observe(query, start, row.Err())
+	return row
+}
+
+func (d *DB) observe(query string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil && err != sql.ErrNoRows {
+		outcome = "error"
+	}
+	d.durations.WithLabelValues(QueryName(query), outcome).Observe(time.Since(start).Seconds())
+}
+
+// QueryName pulls the name out of the "-- name: GetChirp :one" comment sqlc
+// puts at the top of each query. Anything else is "other", so ad-hoc SQL
+// can't blow up the number of series.
+func QueryName(query string) string {
+	rest, ok := strings.CutPrefix(query, "-- name: ")
+	if !ok {
+		return "other"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
@@ -0,0 +1,338 @@
+// Package metrics keeps counters and histograms in memory and renders them
+// in the Prometheus text exposition format, without depending on the
+// Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets suit request latencies, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is one metric family, or a set of them, in a Registry.
+type collector interface {
+	writeTo(w *bufio.Writer)
+}
+
+// Registry is the set of metrics exposed by a Handler.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo renders every registered metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family holds the series of one metric, keyed by their label values.
+type family[T any] struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	newSeries  func() T
+
+	mu     sync.RWMutex
+	series map[string]*labeledSeries[T]
+}
+
+type labeledSeries[T any] struct {
+	labelValues []string
+	value       T
+}
+
+func (f *family[T]) with(labelValues []string) T {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s.value
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok = f.series[key]
+	if !ok {
+		s = &labeledSeries[T]{labelValues: append([]string(nil), labelValues...), value: f.newSeries()}
+		f.series[key] = s
+	}
+	return s.value
+}
+
+// sorted returns the series in a stable order so scrapes are diffable.
+func (f *family[T]) sorted() []*labeledSeries[T] {
+	f.mu.RLock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*labeledSeries[T], len(keys))
+	for i, key := range keys {
+		series[i] = f.series[key]
+	}
+	f.mu.RUnlock()
+
+	return series
+}
+
+func (f *family[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a counter split up by labels.
+type CounterVec struct {
+	family[*Counter]
+}
+
+// NewCounterVec registers a counter. Without label names it has exactly one
+// series, reached with WithLabelValues().
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{family[*Counter]{
+		name:       name,
+		help:       help,
+		kind:       "counter",
+		labelNames: labelNames,
+		newSeries:  func() *Counter { return &Counter{} },
+		series:     make(map[string]*labeledSeries[*Counter]),
+	}}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) WithLabelValues(labelValues ...string) *Counter {
+	return c.with(labelValues)
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		writeSample(w, c.name, c.labelNames, s.labelValues, "", "", s.value.value())
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramVec is a histogram split up by labels.
+type HistogramVec struct {
+	family[*Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds,
+// which must be sorted. The +Inf bucket is implied.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets for " + name + " aren't sorted")
+	}
+	h := &HistogramVec{buckets: buckets}
+	h.family = family[*Histogram]{
+		name:       name,
+		help:       help,
+		kind:       "histogram",
+		labelNames: labelNames,
+		newSeries: func() *Histogram {
+			return &Histogram{upperBounds: buckets, counts: make([]uint64, len(buckets))}
+		},
+		series: make(map[string]*labeledSeries[*Histogram]),
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	return h.with(labelValues)
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		s.value.mu.Lock()
+		counts := append([]uint64(nil), s.value.counts...)
+		sum, count := s.value.sum, s.value.count
+		s.value.mu.Unlock()
+
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labelNames, s.labelValues, "", "", sum)
+		writeSample(w, h.name+"_count", h.labelNames, s.labelValues, "", "", float64(count))
+	}
+}
+
+// gaugeFunc is a gauge whose value is read when the registry is scraped.
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls value on every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	r.register(name, &gaugeFunc{name: name, help: help, value: value})
+}
+
+func (g *gaugeFunc) writeTo(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	writeSample(w, g.name, nil, nil, "", "", g.value())
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/<name>, or rewrites the file with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.WriteFile(path, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRegistryWriteTo(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("test_requests_total", "Requests by method and code.\nSecond line with a \\ backslash.", "method", "code")
+	requests.WithLabelValues("POST", "201").Inc()
+	requests.WithLabelValues("GET", "200").Add(2)
+	requests.WithLabelValues("GET", "200").Inc()
+	requests.WithLabelValues("GET", "404").Add(0.5)
+	// label values are escaped, help text too
+	requests.WithLabelValues("say \"hi\"\n", `C:\path`).Inc()
+
+	plain := registry.NewCounterVec("test_events_total", "A counter without labels.")
+	plain.WithLabelValues().Inc()
+
+	// an observation equal to an upper bound falls in that bucket, one
+	// above the last bound only in +Inf
+	durations := registry.NewHistogramVec("test_duration_seconds", "Durations.", []float64{0.1, 0.5, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.3, 1, 7} {
+		durations.WithLabelValues("/api/chirps").Observe(v)
+	}
+	durations.WithLabelValues("/admin/metrics").Observe(0.2)
+
+	registry.NewGaugeFunc("test_connections", "Open connections.", func() float64 { return 3 })
+
+	// registered but never used: only the header is written
+	registry.NewCounterVec("test_unused_total", "Never incremented.", "kind")
+
+	buf := &bytes.Buffer{}
+	n, err := registry.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+
+	checkGolden(t, "registry.golden", buf.Bytes())
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "First.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering test_total twice didn't panic")
+		}
+	}()
+	registry.NewCounterVec("test_total", "Second.")
+}
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"-- name: GetChirp :one\nSELECT * FROM chirps WHERE id = $1", "GetChirp"},
+		{"-- name: DeleteAllUsers :exec\nDELETE FROM users", "DeleteAllUsers"},
+		{"SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version", "other"},
+		{"  -- name: Indented :one\nSELECT 1", "other"},
+		{"", "other"},
+	}
+
+	for _, tt := range tests {
+		got := QueryName(tt.query)
+		if got != tt.want {
+			t.Errorf("QueryName(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+type runtimeCollector struct {
+	start time.Time
+}
+
+// RegisterRuntime adds Go runtime stats (goroutines, memory, GC) and the
+// process uptime to the registry.
+func (r *Registry) RegisterRuntime() {
+	r.register("go_runtime", &runtimeCollector{start: time.Now()})
+}
+
+func (c *runtimeCollector) writeTo(w *bufio.Writer) {
+	// ReadMemStats stops the world, so read it once per scrape
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		writeSample(w, name, nil, nil, "", "", value)
+	}
+	counter := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		writeSample(w, name, nil, nil, "", "", value)
+	}
+
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	gauge("go_gomaxprocs", "Number of OS threads that can run Go code at once.", float64(runtime.GOMAXPROCS(0)))
+	gauge("go_memstats_alloc_bytes", "Bytes of allocated heap objects.", float64(stats.Alloc))
+	gauge("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", float64(stats.HeapInuse))
+	gauge("go_memstats_heap_objects", "Number of allocated heap objects.", float64(stats.HeapObjects))
+	gauge("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", float64(stats.Sys))
+	counter("go_memstats_alloc_bytes_total", "Total bytes allocated for heap objects.", float64(stats.TotalAlloc))
+	counter("go_memstats_mallocs_total", "Total number of heap objects allocated.", float64(stats.Mallocs))
+	counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(stats.NumGC))
+	counter("go_gc_pause_seconds_total", "Total time the world was stopped for GC.", float64(stats.PauseTotalNs)/float64(time.Second))
+	gauge("process_uptime_seconds", "Seconds since the process started.", time.Since(c.start).Seconds())
+}
@@ -0,0 +1,82 @@
+// Package safehttp builds HTTP clients for requests to URLs that users hand
+// us, which must not be able to reach into our own network.
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("address is not publicly routable")
+
+// NewTransport returns a transport that refuses to connect to loopback,
+// private, link-local and other non-public addresses. The check runs on the
+// address actually dialed, so a DNS name (or a redirect) pointing inside the
+// network is caught as well.
+func NewTransport(timeout time.Duration) *http.Transport {
+	return NewTransportWithCheck(timeout, func(addrPort netip.AddrPort) bool {
+		return IsPublic(addrPort.Addr())
+	})
+}
+
+// NewTransportWithCheck is NewTransport with allowed deciding which
+// addresses may be dialed. Tests use it to let exactly one httptest server
+// through.
+func NewTransportWithCheck(timeout time.Duration, allowed func(netip.AddrPort) bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowed(addrPort) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+}
+
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	// ranges IsPrivate doesn't cover: carrier-grade NAT and the IPv4 /
+	// IPv6 documentation and benchmarking blocks
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "uploads")
+	store, err := NewLocalStore(root, "/app/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func TestPutAndDelete(t *testing.T) {
+	store, root := newTestStore(t)
+	ctx := context.Background()
+
+	err := store.Put(ctx, "avatars/u1/a.png", strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second Put replaces the blob
+	err = store.Put(ctx, "avatars/u1/a.png", strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "avatars", "u1", "a.png"))
+	if err != nil || string(data) != "second" {
+		t.Fatalf("stored %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "avatars", "u1"))
+	if len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+
+	if got := store.URL("avatars/u1/a.png"); got != "/app/uploads/avatars/u1/a.png" {
+		t.Errorf("URL = %q", got)
+	}
+
+	err = store.Delete(ctx, "avatars/u1/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(root, "avatars", "u1", "a.png"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("blob still there: %v", err)
+	}
+
+	// deleting twice is fine, the blob is gone either way
+	err = store.Delete(ctx, "avatars/u1/a.png")
+	if err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+}
+
+// A failed write leaves neither the blob nor a temp file.
+func TestPutFailedReader(t *testing.T) {
+	store, root := newTestStore(t)
+
+	err := store.Put(context.Background(), "chirps/c1/x.png", failingReader{})
+	if err == nil {
+		t.Fatal("Put succeeded")
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(root, "chirps", "c1"))
+	if len(entries) != 0 {
+		t.Errorf("left behind: %v", entries)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestKeysStayInsideRoot(t *testing.T) {
+	store, root := newTestStore(t)
+	ctx := context.Background()
+
+	// a file next to the store that a traversal would reach
+	outside := filepath.Join(filepath.Dir(root), "secret.env")
+	err := os.WriteFile(outside, []byte("SECRET=x"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{
+		"../secret.env",
+		"avatars/../../secret.env",
+		"avatars/..",
+		"..",
+		"",
+		"/",
+		".",
+	} {
+		if err := store.Put(ctx, key, strings.NewReader("overwritten")); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+
+	data, err := os.ReadFile(outside)
+	if err != nil || string(data) != "SECRET=x" {
+		t.Errorf("file outside the root changed: %q, %v", data, err)
+	}
+
+	// an absolute key is taken relative to the root
+	err = store.Put(ctx, "/etc/passwd", strings.NewReader("inside"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(filepath.Join(root, "etc", "passwd"))
+	if err != nil || string(data) != "inside" {
+		t.Errorf("absolute key stored %q, %v", data, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Package stream fans events out to long-lived client connections such as
+// Server-Sent Events streams.
+package stream
+
+import (
+	"sync"
+)
+
+type Event struct {
+	// unique across server instances, clients resume from it
+	ID   string
+	Type string
+	Data any
+}
+
+// Client is one connection's subscription. Events arrive on Events; when
+// the client falls too far behind the hub closes the channel and the
+// connection should end, leaving the client to reconnect and resume.
+type Client struct {
+	Events <-chan Event
+
+	events  chan Event
+	dropped bool
+}
+
+// Hub keeps the most recent events in a ring buffer so that clients coming
+// back with the last ID they saw get what they missed.
+type Hub struct {
+	mu       sync.Mutex
+	ring     []Event
+	next     int
+	full     bool
+	clients  map[*Client]struct{}
+	queueLen int
+	closed   bool
+}
+
+func NewHub(ringSize, clientQueueLen int) *Hub {
+	return &Hub{
+		ring:     make([]Event, ringSize),
+		clients:  make(map[*Client]struct{}),
+		queueLen: clientQueueLen,
+	}
+}
+
+// Subscribe registers a client. With a lastEventID it also returns the
+// buffered events that came after it; resumed is false when that ID is no
+// longer (or never was) in the buffer, so the client may have missed some.
+func (h *Hub) Subscribe(lastEventID string) (client *Client, backlog []Event, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan Event, h.queueLen)
+	client = &Client{Events: events, events: events}
+	if h.closed {
+		close(events)
+		return client, nil, false
+	}
+	h.clients[client] = struct{}{}
+
+	if lastEventID == "" {
+		return client, nil, true
+	}
+
+	buffered := h.buffered()
+	for i, event := range buffered {
+		if event.ID == lastEventID {
+			return client, buffered[i+1:], true
+		}
+	}
+
+	return client, nil, false
+}
+
+func (h *Hub) Unsubscribe(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		if !client.dropped {
+			close(client.events)
+		}
+	}
+}
+
+// Publish buffers the event and hands it to every client without blocking:
+// a client whose queue is full is dropped rather than holding up the rest.
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.ring[h.next] = event
+	h.next = (h.next + 1) % len(h.ring)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	for client := range h.clients {
+		if client.dropped {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default:
+			client.dropped = true
+			close(client.events)
+		}
+	}
+}
+
+// Close ends every client's stream.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for client := range h.clients {
+		if !client.dropped {
+			client.dropped = true
+			close(client.events)
+		}
+	}
+}
+
+// buffered returns the ring's contents oldest first. Callers hold h.mu.
+func (h *Hub) buffered() []Event {
+	if !h.full {
+		return append([]Event(nil), h.ring[:h.next]...)
+	}
+
+	return append(append([]Event(nil), h.ring[h.next:]...), h.ring[:h.next]...)
+}
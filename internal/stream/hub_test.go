@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func publishN(h *Hub, from, to int) {
+	for i := from; i <= to; i++ {
+		h.Publish(Event{ID: strconv.Itoa(i), Type: "chirp"})
+	}
+}
+
+func ids(events []Event) []string {
+	out := make([]string, 0, len(events))
+	for _, event := range events {
+		out = append(out, event.ID)
+	}
+	return out
+}
+
+// drain reads what is queued for the client without blocking and reports
+// whether the channel was closed.
+func drain(c *Client) (got []string, closed bool) {
+	for {
+		select {
+		case event, ok := <-c.Events:
+			if !ok {
+				return got, true
+			}
+			got = append(got, event.ID)
+		default:
+			return got, false
+		}
+	}
+}
+
+func TestSubscribeResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		published   int
+		lastEventID string
+		wantBacklog []string
+		wantResumed bool
+	}{
+		{"fresh connection", 3, "", nil, true},
+		{"resume from the middle", 3, "1", []string{"2", "3"}, true},
+		{"resume from the latest", 3, "3", nil, true},
+		{"unknown id", 3, "nope", nil, false},
+		{"nothing published yet", 0, "1", nil, false},
+		// a ring of 4 holds 7..10 after 10 events
+		{"evicted id", 10, "6", nil, false},
+		{"oldest buffered id", 10, "7", []string{"8", "9", "10"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(4, 8)
+			publishN(h, 1, tt.published)
+
+			client, backlog, resumed := h.Subscribe(tt.lastEventID)
+			defer h.Unsubscribe(client)
+
+			if resumed != tt.wantResumed || !slices.Equal(ids(backlog), tt.wantBacklog) {
+				t.Errorf("Subscribe(%q) = %v, %v; want %v, %v", tt.lastEventID, ids(backlog), resumed, tt.wantBacklog, tt.wantResumed)
+			}
+		})
+	}
+}
+
+func TestBufferedWrapsAround(t *testing.T) {
+	tests := []struct {
+		published int
+		want      []string
+	}{
+		{0, []string{}},
+		{2, []string{"1", "2"}},
+		{3, []string{"1", "2", "3"}},
+		{4, []string{"2", "3", "4"}},
+		{6, []string{"4", "5", "6"}},
+		{7, []string{"5", "6", "7"}},
+	}
+
+	for _, tt := range tests {
+		h := NewHub(3, 1)
+		publishN(h, 1, tt.published)
+
+		h.mu.Lock()
+		got := ids(h.buffered())
+		h.mu.Unlock()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("after %d events buffered() = %v, want %v", tt.published, got, tt.want)
+		}
+	}
+}
+
+func TestPublishDropsSlowClient(t *testing.T) {
+	h := NewHub(16, 2)
+	slow, _, _ := h.Subscribe("")
+	fast, _, _ := h.Subscribe("")
+
+	publishN(h, 1, 2)
+	got, closed := drain(fast)
+	if closed || !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("fast client got %v, closed %v", got, closed)
+	}
+
+	// slow never read, so its queue of 2 is full and the third event drops
+	// it; fast keeps getting events
+	publishN(h, 3, 4)
+	got, closed = drain(slow)
+	if !closed || !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("slow client got %v, closed %v", got, closed)
+	}
+	got, closed = drain(fast)
+	if closed || !slices.Equal(got, []string{"3", "4"}) {
+		t.Errorf("fast client got %v, closed %v", got, closed)
+	}
+
+	// later events skip the dropped client instead of closing it again
+	publishN(h, 5, 5)
+	h.Unsubscribe(slow)
+	h.Unsubscribe(fast)
+	if _, closed = drain(fast); !closed {
+		t.Error("Unsubscribe didn't close the stream")
+	}
+}
+
+func TestClose(t *testing.T) {
+	h := NewHub(4, 4)
+	publishN(h, 1, 2)
+	client, _, _ := h.Subscribe("")
+
+	h.Close()
+	if _, closed := drain(client); !closed {
+		t.Error("Close didn't end the client's stream")
+	}
+	// unsubscribing after Close mustn't close the channel twice
+	h.Unsubscribe(client)
+
+	// nothing gets through once closed
+	publishN(h, 3, 3)
+	late, backlog, resumed := h.Subscribe("1")
+	got, closed := drain(late)
+	if !closed || len(got) != 0 || backlog != nil || resumed {
+		t.Errorf("Subscribe after Close: got %v, closed %v, backlog %v, resumed %v", got, closed, backlog, resumed)
+	}
+	h.Unsubscribe(late)
+}
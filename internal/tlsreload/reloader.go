@@ -0,0 +1,168 @@
+// Package tlsreload serves TLS from certificate files that can be replaced
+// while the server runs, e.g. when a certificate is renewed.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds the current certificate, and optionally the CAs client
+// certificates are checked against. New handshakes pick up a reload;
+// connections already open keep the certificate they were set up with.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamp     string
+}
+
+// New loads the files once, so a bad certificate fails startup rather than
+// the first handshake. clientCAFile may be empty.
+func New(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. On error the previous certificate stays in
+// use, so a half-written renewal doesn't take the server down.
+func (r *Reloader) Reload() error {
+	stamp, err := r.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("loading client CAs: no certificates found")
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.stamp = stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// currentStamp sums up the files' sizes and modification times, so Watch
+// can tell they changed without reading them.
+func (r *Reloader) currentStamp() (string, error) {
+	stamp := ""
+	for _, name := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+// Watch reloads when the files change, checking every interval, and
+// whenever something arrives on trigger (e.g. SIGHUP). It returns when ctx
+// is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, trigger <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// the files as last tried, so a broken renewal is reported once rather
+	// than on every tick until it's fixed
+	r.mu.RLock()
+	tried := r.stamp
+	r.mu.RUnlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-ticker.C:
+			// a missing file is usually mid-replacement; look again next tick
+			stamp, err := r.currentStamp()
+			if err != nil || stamp == tried {
+				continue
+			}
+			tried = stamp
+		}
+
+		err := r.Reload()
+		if err != nil {
+			slog.Error("Error reloading TLS certificate, keeping the previous one", "cert_file", r.certFile, "error", err)
+			continue
+		}
+		slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+	}
+}
+
+// TLSConfig returns a server config limited to TLS 1.2 and up with forward
+// secret AEAD ciphers. With client CAs, clients may present a certificate;
+// whether one is required is up to the handler, see VerifiedClient.
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		// TLS 1.3 suites aren't configurable, these only apply to 1.2
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	config := base.Clone()
+	// each handshake gets the certificate and CAs current at that moment
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		handshake := base.Clone()
+		handshake.Certificates = []tls.Certificate{*r.cert}
+		if r.clientCAs != nil {
+			handshake.ClientCAs = r.clientCAs
+			handshake.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		return handshake, nil
+	}
+	return config
+}
+
+// VerifiedClient reports whether the connection presented a client
+// certificate that chains to one of the client CAs.
+func VerifiedClient(state *tls.ConnectionState) bool {
+	return state != nil && len(state.VerifiedChains) > 0
+}
@@ -0,0 +1,335 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for localhost, or for a client
+// with client set.
+func (ca *testCA) issue(t *testing.T, serial int64, client bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// files are the paths a Reloader reads in a test.
+type files struct {
+	t                   *testing.T
+	cert, key, clientCA string
+	writes              int
+}
+
+func newFiles(t *testing.T) *files {
+	dir := t.TempDir()
+	return &files{
+		t:        t,
+		cert:     filepath.Join(dir, "cert.pem"),
+		key:      filepath.Join(dir, "key.pem"),
+		clientCA: filepath.Join(dir, "client-ca.pem"),
+	}
+}
+
+// write replaces the certificate and key, moving their modification time
+// forward so every write is seen as a change even on coarse clocks.
+func (f *files) write(certPEM, keyPEM []byte) {
+	f.t.Helper()
+	f.writes++
+	later := time.Now().Add(time.Duration(f.writes) * time.Minute)
+	for name, data := range map[string][]byte{f.cert: certPEM, f.key: keyPEM} {
+		err := os.WriteFile(name, data, 0o600)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		err = os.Chtimes(name, later, later)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// handshake connects to a server using config and returns the serial of the
+// certificate it served and the server's view of the connection.
+func handshake(t *testing.T, config *tls.Config, ca *testCA, clientCert *tls.Certificate) (int64, tls.ConnectionState, error) {
+	t.Helper()
+	// a real connection rather than net.Pipe, whose unbuffered writes
+	// deadlock when the server sends an alert while the client is writing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if clientCert != nil {
+		// always present it; by default the client holds back a certificate
+		// the server didn't ask for by CA
+		clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert, nil
+		}
+	}
+
+	server := tls.Server(serverConn, config)
+	serverDone := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		// unblock the client if the server gives up first
+		serverConn.Close()
+		serverDone <- err
+	}()
+
+	client := tls.Client(clientConn, clientConfig)
+	clientErr := client.Handshake()
+	if clientErr == nil {
+		// in TLS 1.3 the server checks the client certificate after the
+		// client is done, so read until the server has had its say
+		client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		client.Read(make([]byte, 1))
+	}
+	clientConn.Close()
+	serverErr := <-serverDone
+	if serverErr != nil {
+		return 0, tls.ConnectionState{}, serverErr
+	}
+	if clientErr != nil {
+		return 0, tls.ConnectionState{}, clientErr
+	}
+	return client.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), server.ConnectionState(), nil
+}
+
+func TestReloadServesNewCertificate(t *testing.T) {
+	ca := newTestCA(t, "server CA")
+	f := newFiles(t)
+	f.write(ca.issue(t, 1, false))
+
+	r, err := New(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+
+	serial, _, err := handshake(t, config, ca, nil)
+	if err != nil || serial != 1 {
+		t.Fatalf("before reload: serial %d, %v", serial, err)
+	}
+
+	f.write(ca.issue(t, 2, false))
+	err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, _, err = handshake(t, config, ca, nil)
+	if err != nil || serial != 2 {
+		t.Errorf("after reload: serial %d, %v", serial, err)
+	}
+}
+
+func TestWatchPicksUpChangedFiles(t *testing.T) {
+	ca := newTestCA(t, "server CA")
+	f := newFiles(t)
+	f.write(ca.issue(t, 1, false))
+
+	r, err := New(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond, nil)
+
+	f.write(ca.issue(t, 2, false))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serial, _, err := handshake(t, config, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial == 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still serving serial %d", serial)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A half-written renewal must not replace a working certificate.
+func TestBrokenFilesKeepPreviousCertificate(t *testing.T) {
+	ca := newTestCA(t, "server CA")
+	certPEM, keyPEM := ca.issue(t, 1, false)
+	_, strayKeyPEM := ca.issue(t, 2, false)
+
+	tests := []struct {
+		name            string
+		certPEM, keyPEM []byte
+	}{
+		{"garbage certificate", []byte("not a certificate"), keyPEM},
+		{"truncated certificate", certPEM[:len(certPEM)/2], keyPEM},
+		{"key doesn't match", certPEM, strayKeyPEM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFiles(t)
+			f.write(certPEM, keyPEM)
+			r, err := New(f.cert, f.key, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.write(tt.certPEM, tt.keyPEM)
+			if r.Reload() == nil {
+				t.Error("Reload succeeded")
+			}
+
+			serial, _, err := handshake(t, r.TLSConfig(), ca, nil)
+			if err != nil || serial != 1 {
+				t.Errorf("serial %d, %v", serial, err)
+			}
+		})
+	}
+}
+
+func TestVerifiedClient(t *testing.T) {
+	serverCA := newTestCA(t, "server CA")
+	clientCA := newTestCA(t, "client CA")
+	otherCA := newTestCA(t, "other CA")
+
+	f := newFiles(t)
+	f.write(serverCA.issue(t, 1, false))
+	err := os.WriteFile(f.clientCA, clientCA.pem, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(f.cert, f.key, f.clientCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+
+	trusted := clientCA.keyPair(t)
+	untrusted := otherCA.keyPair(t)
+
+	// no certificate: the handshake works, but the client isn't verified
+	_, state, err := handshake(t, config, serverCA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifiedClient(&state) {
+		t.Error("client without a certificate is verified")
+	}
+
+	_, state, err = handshake(t, config, serverCA, &trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifiedClient(&state) {
+		t.Error("client with a certificate from the client CA isn't verified")
+	}
+
+	_, _, err = handshake(t, config, serverCA, &untrusted)
+	if err == nil {
+		t.Error("handshake with a certificate from another CA succeeded")
+	}
+
+	if VerifiedClient(nil) {
+		t.Error("plain HTTP connection is verified")
+	}
+}
+
+// keyPair is a client certificate signed by ca.
+func (ca *testCA) keyPair(t *testing.T) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, 100, true)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
@@ -0,0 +1,132 @@
+// Package webhook delivers signed event payloads to subscriber URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/safehttp"
+	"github.com/gaschneider/go/httpserver/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	EventHeader     = "X-Chirpy-Event"
+	DeliveryHeader  = "X-Chirpy-Delivery"
+	TimestampHeader = "X-Chirpy-Timestamp"
+	SignatureHeader = "X-Chirpy-Signature"
+
+	sendTimeout      = 10 * time.Second
+	maxResponseBytes = 4 << 10
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+type Delivery struct {
+	ID     string
+	Event  string
+	URL    string
+	Secret string
+	Body   []byte
+}
+
+// Sender posts deliveries. Redirects are not followed: a subscriber that
+// moved has to update its URL.
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender returns a sender that may reach any address. It is meant for
+// subscriptions set up by admins, which can point inside our network.
+func NewSender() *Sender {
+	return newSender(http.DefaultTransport)
+}
+
+// NewPublicSender returns a sender that only connects to public addresses,
+// for URLs registered by users.
+func NewPublicSender() *Sender {
+	return newSender(safehttp.NewTransport(sendTimeout))
+}
+
+func newSender(transport http.RoundTripper) *Sender {
+	return &Sender{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   sendTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send posts the delivery signed the same way Polka signs its webhooks to
+// us: an HMAC-SHA256 of "<timestamp>.<body>" in a "v1=<hex>" header. It
+// returns the response status, or 0 when no response came back; anything
+// but a 2xx is an error.
+func (s *Sender) Send(ctx context.Context, delivery Delivery) (statusCode int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "webhook "+delivery.Event,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("webhook.delivery_id", delivery.ID),
+			attribute.String("url.full", delivery.URL),
+		),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		tracing.End(span, err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ChirpyWebhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, "v1="+auth.SignPayload(delivery.Secret, timestamp, delivery.Body))
+	// lets subscribers that trace too continue the trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Backoff is how long to wait before the next try after the given number of
+// failed attempts: exponential from 30 seconds up to 6 hours, with up to 20%
+// jitter so a subscriber coming back up isn't hit by every retry at once.
+func Backoff(attempts int) time.Duration {
+	delay := maxBackoff
+	if attempts < 20 {
+		delay = min(baseBackoff<<max(attempts-1, 0), maxBackoff)
+	}
+
+	return delay + time.Duration(rand.Int64N(int64(delay/5)+1))
+}
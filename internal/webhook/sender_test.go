@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/safehttp"
+)
+
+func TestSendSigns(t *testing.T) {
+	const secret = "whsec_subscriber"
+	body := []byte(`{"event":"chirp.created"}`)
+
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	status, err := NewSender().Send(context.Background(), Delivery{
+		ID:     "d1",
+		Event:  "chirp.created",
+		URL:    server.URL,
+		Secret: secret,
+		Body:   body,
+	})
+	if err != nil || status != 204 {
+		t.Fatalf("Send() = %d, %v", status, err)
+	}
+
+	if got.Header.Get(EventHeader) != "chirp.created" || got.Header.Get(DeliveryHeader) != "d1" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	err = auth.VerifySignature([]string{secret}, got.Header.Get(TimestampHeader), got.Header.Get(SignatureHeader), gotBody, time.Minute)
+	if err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+	err = auth.VerifySignature([]string{"whsec_other"}, got.Header.Get(TimestampHeader), got.Header.Get(SignatureHeader), gotBody, time.Minute)
+	if err == nil {
+		t.Error("signature verifies with another secret")
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+		wantErr bool
+	}{
+		{"ok", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) }, 200, false},
+		{"accepted", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(202) }, 202, false},
+		{"not found", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) }, 404, true},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+			io.WriteString(w, strings.Repeat("x", 1<<20))
+		}, 500, true},
+		{"redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/moved", http.StatusFound)
+		}, 302, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			followed := false
+			mux := http.NewServeMux()
+			mux.Handle("/hook", tt.handler)
+			mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+				followed = true
+				w.WriteHeader(200)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			status, err := NewSender().Send(context.Background(), Delivery{ID: "d1", Event: "e", URL: server.URL + "/hook", Secret: "s"})
+			if status != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("Send() = %d, %v", status, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "status") {
+				t.Errorf("error doesn't name the status: %v", err)
+			}
+			if followed {
+				t.Error("redirect followed")
+			}
+		})
+	}
+}
+
+func TestPublicSenderRefusesLoopback(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	status, err := NewPublicSender().Send(context.Background(), Delivery{ID: "d1", Event: "e", URL: server.URL, Secret: "s"})
+	if !errors.Is(err, safehttp.ErrForbiddenAddress) || status != 0 {
+		t.Errorf("Send() = %d, %v; want %v", status, err, safehttp.ErrForbiddenAddress)
+	}
+	if reached {
+		t.Error("loopback server was reached")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	previous := time.Duration(0)
+	for attempts := 0; attempts <= 40; attempts++ {
+		want := min(baseBackoff<<max(attempts-1, 0), maxBackoff)
+		if attempts >= 20 {
+			want = maxBackoff
+		}
+
+		for range 50 {
+			got := Backoff(attempts)
+			if got < want || got > want+want/5 {
+				t.Fatalf("Backoff(%d) = %v, want within [%v, %v]", attempts, got, want, want+want/5)
+			}
+			if got < baseBackoff || got > maxBackoff+maxBackoff/5 {
+				t.Fatalf("Backoff(%d) = %v, outside [%v, %v]", attempts, got, baseBackoff, maxBackoff+maxBackoff/5)
+			}
+		}
+		if want < previous {
+			t.Errorf("Backoff(%d) shrank", attempts)
+		}
+		previous = want
+	}
+}
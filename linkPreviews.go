@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/chirptext"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/linkpreview"
+)
+
+const (
+	linkPreviewWorkers   = 4
+	linkPreviewQueueSize = 256
+	linkPreviewTTL       = 24 * time.Hour
+	maxPreviewsPerChirp  = 2
+)
+
+type LinkPreviewDTO struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// linkPreviewStore caches fetched previews in the link_previews table.
+type linkPreviewStore struct {
+	db *database.Queries
+}
+
+func (s linkPreviewStore) IsFresh(ctx context.Context, url string) (bool, error) {
+	preview, err := s.db.GetLinkPreview(ctx, url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return time.Since(preview.FetchedAt) < linkPreviewTTL, nil
+}
+
+func (s linkPreviewStore) Save(ctx context.Context, url string, preview linkpreview.Preview, fetchErr error) error {
+	params := database.UpsertLinkPreviewParams{
+		Url:         url,
+		Ok:          fetchErr == nil,
+		Title:       preview.Title,
+		Description: preview.Description,
+		ImageUrl:    preview.ImageURL,
+		SiteName:    preview.SiteName,
+	}
+	if fetchErr != nil {
+		params.Error = fetchErr.Error()
+	}
+
+	return s.db.UpsertLinkPreview(ctx, params)
+}
+
+// chirpPreviewURLs picks the links in a chirp that get a preview.
+func chirpPreviewURLs(body string) []string {
+	urls := chirptext.URLs(body)
+	if len(urls) > maxPreviewsPerChirp {
+		urls = urls[:maxPreviewsPerChirp]
+	}
+
+	return urls
+}
+
+// loadLinkPreviews returns the cached previews for the links in the given
+// bodies. Links that haven't been fetched yet (or failed) are simply missing.
+func (cfg *apiConfig) loadLinkPreviews(ctx context.Context, bodies []string) (map[string]LinkPreviewDTO, error) {
+	urls := make([]string, 0)
+	for _, body := range bodies {
+		urls = append(urls, chirpPreviewURLs(body)...)
+	}
+
+	previewsByURL := make(map[string]LinkPreviewDTO)
+	if len(urls) == 0 {
+		return previewsByURL, nil
+	}
+
+	previews, err := cfg.db.GetLinkPreviewsByUrls(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preview := range previews {
+		previewsByURL[preview.Url] = LinkPreviewDTO{
+			URL:         preview.Url,
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageURL:    preview.ImageUrl,
+			SiteName:    preview.SiteName,
+		}
+	}
+
+	return previewsByURL, nil
+}
@@ -1,63 +1,311 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync/atomic"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/gaschneider/go/httpserver/internal/config"
 	"github.com/gaschneider/go/httpserver/internal/database"
-	"github.com/joho/godotenv"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/gaschneider/go/httpserver/internal/linkpreview"
+	"github.com/gaschneider/go/httpserver/internal/metrics"
+	"github.com/gaschneider/go/httpserver/internal/storage"
+	"github.com/gaschneider/go/httpserver/internal/stream"
+	"github.com/gaschneider/go/httpserver/internal/tlsreload"
+	"github.com/gaschneider/go/httpserver/internal/tracing"
+	"github.com/gaschneider/go/httpserver/internal/webhook"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	secret         string
-	polkaKey       string
+	pageHits *pageHitCounter
+	db       *database.Queries
+	dbConn   *sql.DB
+	platform string
+	secret   string
+	polkaKey string
+	// when set, Polka webhooks must be signed with one of these instead of
+	// carrying polkaKey; several are allowed while keys are rotated
+	polkaSigningSecrets     []string
+	polkaSignatureTolerance time.Duration
+	// ApiKey for the admin API, see authorizeAdmin
+	adminKey string
+	// with client CAs configured, /admin/ also needs a client certificate
+	adminClientCertRequired bool
+	blobStore               storage.BlobStore
+	// accountDeletionMode is either "cascade" (default) or "anonymize"
+	accountDeletionMode string
+	chirpLengthLimits   chirpLengthLimits
+	linkPreviews        *linkpreview.Pool
+	// admin webhooks may target internal hosts, users' only public ones
+	webhookSender       *webhook.Sender
+	publicWebhookSender *webhook.Sender
+	chirpStream         *stream.Hub
+	notificationStream  *stream.Hub
+	wsHub               *wsHub
+	metrics             *appMetrics
+	readiness           *readiness
+}
+
+// withTx returns queries that run in tx. Use it over db.WithTx, which would
+// bypass the query timings and spans.
+func (cfg *apiConfig) withTx(tx *sql.Tx) *database.Queries {
+	return database.New(instrumentDB(tx, cfg.metrics))
+}
+
+func instrumentDB(db metrics.DBTX, appMetrics *appMetrics) database.DBTX {
+	return tracing.InstrumentDB(metrics.InstrumentDB(db, appMetrics.dbQueryDurations))
+}
+
+// chirpLengthLimits is the maximum chirp length in characters for each plan.
+type chirpLengthLimits struct {
+	standard int
+	red      int
 }
 
 func main() {
-	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	platform := os.Getenv("PLATFORM")
-	secret := os.Getenv("SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
-	db, err := sql.Open("postgres", dbURL)
+	settings, loaded, err := config.Load(config.Options{Args: os.Args[1:], DotEnv: ".env"})
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+	if loaded.PrintConfig {
+		settings.Print(os.Stdout)
 		return
 	}
 
-	dbQueries := database.New(db)
+	// log.Printf goes through this logger as well
+	slog.SetDefault(newLogger(os.Stdout, settings.LogLevel))
+	slog.Info("Loaded configuration", "file", loaded.File, "config", settings)
+	if len(settings.PolkaWebhookSecrets) == 0 {
+		// anyone who learns the key can forge upgrades, and replays aren't caught
+		slog.Warn("Polka webhooks are checked against the static polka_key only; set polka_webhook_secrets to verify their signatures")
+	}
+
+	lengthLimits := chirpLengthLimits{
+		standard: settings.ChirpMaxLength,
+		red:      settings.ChirpMaxLengthRed,
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: settings.OTelServiceName,
+		Exporter:    settings.TracingExporter,
+		File:        settings.TracingFile,
+	})
+	if err != nil {
+		slog.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Open doesn't connect; an unreachable database shows up in readyz
+	db, err := sql.Open("postgres", settings.DBURL)
+	if err != nil {
+		slog.Error("Error opening database", "error", err)
+		os.Exit(1)
+	}
+
+	appMetrics := newAppMetrics()
+	dbQueries := database.New(instrumentDB(db, appMetrics))
+
+	// uploads live under ./uploads, served at /app/uploads/
+	blobStore, err := storage.NewLocalStore("uploads", "/app/uploads")
+	if err != nil {
+		slog.Error("Error setting up uploads", "error", err)
+		os.Exit(1)
+	}
+
+	var certificates *tlsreload.Reloader
+	if settings.TLS() {
+		certificates, err = tlsreload.New(settings.TLSCertFile, settings.TLSKeyFile, settings.TLSClientCAFile)
+		if err != nil {
+			slog.Error("Error loading TLS certificate", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	linkPreviews := linkpreview.NewPool(linkpreview.NewFetcher(), linkPreviewStore{db: dbQueries}, linkPreviewWorkers, linkPreviewQueueSize)
 
 	serveMux := http.NewServeMux()
-	config := apiConfig{fileserverHits: atomic.Int32{}, db: dbQueries, platform: platform, secret: secret, polkaKey: polkaKey}
-	fileServerHandler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
-	serveMux.Handle("/app/", config.middlewareMetricsInc(fileServerHandler))
-	serveMux.HandleFunc("GET /admin/metrics", config.displayCountRequestsHandler)
-	serveMux.HandleFunc("POST /admin/reset", config.resetCountRequestsHandler)
+	cfg := apiConfig{
+		pageHits:                newPageHitCounter(),
+		db:                      dbQueries,
+		dbConn:                  db,
+		platform:                settings.Platform,
+		secret:                  settings.Secret,
+		polkaKey:                settings.PolkaKey,
+		polkaSigningSecrets:     settings.PolkaWebhookSecrets,
+		polkaSignatureTolerance: settings.PolkaSignatureTolerance,
+		adminKey:                settings.AdminAPIKey,
+		adminClientCertRequired: settings.TLSClientCAFile != "",
+		blobStore:               blobStore,
+		accountDeletionMode:     settings.AccountDeletionMode,
+		chirpLengthLimits:       lengthLimits,
+		linkPreviews:            linkPreviews,
+		webhookSender:           webhook.NewSender(),
+		publicWebhookSender:     webhook.NewPublicSender(),
+		chirpStream:             stream.NewHub(chirpStreamRingSize, chirpStreamClientQueue),
+		notificationStream:      stream.NewHub(notificationStreamRingSize, notificationStreamClientQueue),
+		wsHub:                   newWSHub(),
+		metrics:                 appMetrics,
+		readiness:               newReadiness(),
+	}
+	appMetrics.registry.NewGaugeFunc("chirpy_websocket_connections", "Open WebSocket connections.", cfg.wsHub.count)
+	// only the front end and uploads are public: the working directory also
+	// holds .env, config files and TLS keys
+	serveMux.Handle("GET /app/{$}", cfg.middlewareMetricsInc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "index.html")
+	})))
+	serveMux.Handle("GET /app/assets/", cfg.middlewareMetricsInc(http.StripPrefix("/app/assets", http.FileServer(http.Dir("assets")))))
+	serveMux.Handle("GET /app/uploads/", cfg.middlewareMetricsInc(http.StripPrefix("/app/uploads", http.FileServer(http.Dir("uploads")))))
+	serveMux.HandleFunc("GET /metrics", cfg.metricsHandler)
+	serveMux.HandleFunc("GET /admin/metrics", cfg.displayCountRequestsHandler)
+	serveMux.HandleFunc("POST /admin/reset", cfg.resetCountRequestsHandler)
+	serveMux.HandleFunc("GET /admin/webhooks/events", cfg.getWebhookEventsHandler)
+	serveMux.HandleFunc("POST /admin/webhooks/events/{eventID}/reprocess", cfg.reprocessWebhookEventHandler)
+	serveMux.HandleFunc("POST /admin/webhooks/subscriptions", cfg.createWebhookSubscriptionHandler(cfg.adminWebhookOwner))
+	serveMux.HandleFunc("GET /admin/webhooks/subscriptions", cfg.getWebhookSubscriptionsHandler(cfg.adminWebhookOwner))
+	serveMux.HandleFunc("DELETE /admin/webhooks/subscriptions/{subscriptionID}", cfg.deleteWebhookSubscriptionHandler(cfg.adminWebhookOwner))
+	serveMux.HandleFunc("GET /admin/webhooks/subscriptions/{subscriptionID}/deliveries", cfg.getWebhookDeliveriesHandler(cfg.adminWebhookOwner))
+	serveMux.HandleFunc("POST /admin/webhooks/subscriptions/{subscriptionID}/deliveries/{deliveryID}/retry", cfg.retryWebhookDeliveryHandler(cfg.adminWebhookOwner))
+
+	serveMux.HandleFunc("POST /api/chirps", cfg.createChirpHandler)
+	serveMux.HandleFunc("GET /api/chirps", cfg.getAllChirpHandler)
+	serveMux.HandleFunc("GET /api/chirps/unpublished", cfg.getUnpublishedChirpsHandler)
+	serveMux.HandleFunc("GET /api/chirps/stream", cfg.getChirpStreamHandler)
+	serveMux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpHandler)
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/attachments", cfg.uploadChirpAttachmentHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/rechirps", cfg.rechirpHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/publish", cfg.publishChirpHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/poll/votes", cfg.votePollHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/bookmark", cfg.bookmarkChirpHandler)
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}/bookmark", cfg.unbookmarkChirpHandler)
+
+	serveMux.HandleFunc("GET /api/bookmarks", cfg.getBookmarksHandler)
+	serveMux.HandleFunc("POST /api/bookmarks/collections", cfg.createBookmarkCollectionHandler)
+	serveMux.HandleFunc("GET /api/bookmarks/collections", cfg.getBookmarkCollectionsHandler)
+	serveMux.HandleFunc("DELETE /api/bookmarks/collections/{collectionID}", cfg.deleteBookmarkCollectionHandler)
 
-	serveMux.HandleFunc("POST /api/chirps", config.createChirpHandler)
-	serveMux.HandleFunc("GET /api/chirps", config.getAllChirpHandler)
-	serveMux.HandleFunc("GET /api/chirps/{chirpID}", config.getChirpHandler)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", config.deleteChirpHandler)
+	serveMux.HandleFunc("POST /api/users", cfg.createUsersHandler)
+	serveMux.HandleFunc("POST /api/login", cfg.loginUserHandler)
+	serveMux.HandleFunc("POST /api/refresh", cfg.refreshTokenHandler)
+	serveMux.HandleFunc("POST /api/revoke", cfg.revokeRefreshTokenHandler)
+	serveMux.HandleFunc("PUT /api/users", cfg.updateUserHandler)
+	serveMux.HandleFunc("DELETE /api/users", cfg.deleteUserHandler)
+	serveMux.HandleFunc("GET /api/users/export", cfg.exportUserHandler)
+	serveMux.HandleFunc("GET /api/users/subscription", cfg.getSubscriptionHandler)
+	serveMux.HandleFunc("POST /api/users/avatar", cfg.uploadAvatarHandler)
+	serveMux.HandleFunc("POST /api/users/{userID}/block", cfg.blockUserHandler)
+	serveMux.HandleFunc("DELETE /api/users/{userID}/block", cfg.unblockUserHandler)
+	serveMux.HandleFunc("POST /api/users/{userID}/mute", cfg.muteUserHandler)
+	serveMux.HandleFunc("DELETE /api/users/{userID}/mute", cfg.unmuteUserHandler)
 
-	serveMux.HandleFunc("POST /api/users", config.createUsersHandler)
-	serveMux.HandleFunc("POST /api/login", config.loginUserHandler)
-	serveMux.HandleFunc("POST /api/refresh", config.refreshTokenHandler)
-	serveMux.HandleFunc("POST /api/revoke", config.revokeRefreshTokenHandler)
-	serveMux.HandleFunc("PUT /api/users", config.updateUserHandler)
+	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaEvents)
 
-	serveMux.HandleFunc("POST /api/polka/webhooks", config.handlePolkaEvents)
+	serveMux.HandleFunc("GET /api/notifications", cfg.getNotificationsHandler)
+	serveMux.HandleFunc("POST /api/notifications/read", cfg.markNotificationsReadHandler)
+	serveMux.HandleFunc("GET /api/notifications/preferences", cfg.getNotificationPreferencesHandler)
+	serveMux.HandleFunc("PUT /api/notifications/preferences", cfg.updateNotificationPreferencesHandler)
 
+	serveMux.HandleFunc("POST /api/webhooks", cfg.createWebhookSubscriptionHandler(cfg.userWebhookOwner))
+	serveMux.HandleFunc("GET /api/webhooks", cfg.getWebhookSubscriptionsHandler(cfg.userWebhookOwner))
+	serveMux.HandleFunc("DELETE /api/webhooks/{subscriptionID}", cfg.deleteWebhookSubscriptionHandler(cfg.userWebhookOwner))
+	serveMux.HandleFunc("GET /api/webhooks/{subscriptionID}/deliveries", cfg.getWebhookDeliveriesHandler(cfg.userWebhookOwner))
+	serveMux.HandleFunc("POST /api/webhooks/{subscriptionID}/deliveries/{deliveryID}/retry", cfg.retryWebhookDeliveryHandler(cfg.userWebhookOwner))
+
+	serveMux.HandleFunc("GET /api/ws", cfg.wsHandler)
 	serveMux.HandleFunc("GET /api/healthz", healthHandler)
+	serveMux.HandleFunc("GET /api/livez", livezHandler)
+	serveMux.HandleFunc("GET /api/readyz", cfg.readyzHandler)
+
+	workers := newWorkerGroup()
+	workers.Go(func(ctx context.Context) { cfg.runPageHitFlusher(ctx, pageHitFlushInterval) })
+	workers.Go(func(ctx context.Context) { cfg.runChirpScheduler(ctx, schedulerPollPeriod) })
+	workers.Go(func(ctx context.Context) { cfg.runSubscriptionExpiry(ctx, subscriptionExpiryInterval) })
+	workers.Go(func(ctx context.Context) { cfg.runWebhookDispatcher(ctx, webhookDispatchInterval) })
+
+	eventBus := events.NewBus(domainEventStore{db: dbQueries})
+	cfg.subscribeToEvents(eventBus)
+	workers.Go(func(ctx context.Context) { eventBus.Run(ctx, eventDispatchInterval) })
+	workers.Go(func(ctx context.Context) { cfg.runStreamListener(ctx, settings.DBURL) })
+
+	server := &http.Server{
+		Addr:              settings.Addr(),
+		Handler:           middlewareTracing(cfg.middlewareRequestLog(cfg.middlewareInstrument(cfg.middlewareAdminClientCert(serveMux)))),
+		ReadHeaderTimeout: settings.HTTPReadHeaderTimeout,
+		ReadTimeout:       settings.HTTPReadTimeout,
+		// long-lived responses (event streams, exports) extend their own
+		// deadline with http.ResponseController
+		WriteTimeout:   settings.HTTPWriteTimeout,
+		IdleTimeout:    settings.HTTPIdleTimeout,
+		MaxHeaderBytes: settings.HTTPMaxHeaderBytes,
+		ErrorLog:       slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	// open streams would otherwise hold Shutdown up until its deadline
+	server.RegisterOnShutdown(cfg.chirpStream.Close)
+	server.RegisterOnShutdown(cfg.notificationStream.Close)
+	server.RegisterOnShutdown(cfg.wsHub.Shutdown)
+	servers := []*http.Server{server}
+
+	if certificates != nil {
+		server.TLSConfig = certificates.TLSConfig()
+		// the certificate is swapped for new handshakes only, so reloading
+		// doesn't touch open connections
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		workers.Go(func(ctx context.Context) { certificates.Watch(ctx, settings.TLSReloadInterval, reload) })
+	}
+	if settings.HTTPRedirectPort != 0 {
+		servers = append(servers, &http.Server{
+			Addr:              settings.RedirectAddr(),
+			Handler:           redirectToHTTPS(settings.Port),
+			ReadHeaderTimeout: settings.HTTPReadHeaderTimeout,
+			ReadTimeout:       settings.HTTPReadTimeout,
+			WriteTimeout:      settings.HTTPWriteTimeout,
+			IdleTimeout:       settings.HTTPIdleTimeout,
+			MaxHeaderBytes:    settings.HTTPMaxHeaderBytes,
+			ErrorLog:          server.ErrorLog,
+		})
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if srv.TLSConfig != nil {
+				serveErr <- srv.ListenAndServeTLS("", "")
+			} else {
+				serveErr <- srv.ListenAndServe()
+			}
+		}()
+		slog.Info("Listening", "addr", srv.Addr, "tls", srv.TLSConfig != nil)
+	}
 
-	server := http.Server{
-		Addr:    ":8081",
-		Handler: serveMux,
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", "error", err)
+		exitCode = 1
+	case <-signals.Done():
+		// a second signal kills the process without waiting
+		stopSignals()
+		slog.Info("Shutting down")
 	}
 
-	server.ListenAndServe()
+	cfg.shutdown(servers, workers, settings.ShutdownDrainDelay, settings.ShutdownTimeout)
+	shutdownTracing(context.Background())
+	os.Exit(exitCode)
 }
@@ -1,10 +1,141 @@
 package main
 
-import "net/http"
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
+	"github.com/gaschneider/go/httpserver/internal/metrics"
+)
+
+// appMetrics is everything exposed on /metrics.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	httpRequests      *metrics.CounterVec
+	httpDurations     *metrics.HistogramVec
+	dbQueryDurations  *metrics.HistogramVec
+	chirpsCreated     *metrics.CounterVec
+	logins            *metrics.CounterVec
+	webhookEvents     *metrics.CounterVec
+	webhookDeliveries *metrics.CounterVec
+}
+
+func newAppMetrics() *appMetrics {
+	registry := metrics.NewRegistry()
+	registry.RegisterRuntime()
+
+	return &appMetrics{
+		registry:          registry,
+		httpRequests:      registry.NewCounterVec("chirpy_http_requests_total", "HTTP requests by route pattern and status code.", "method", "route", "code"),
+		httpDurations:     registry.NewHistogramVec("chirpy_http_request_duration_seconds", "Time to serve HTTP requests by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		dbQueryDurations:  registry.NewHistogramVec("chirpy_db_query_duration_seconds", "Time to run database queries by sqlc query name.", metrics.DBBuckets, "query", "outcome"),
+		chirpsCreated:     registry.NewCounterVec("chirpy_chirps_created_total", "Chirps created, by kind.", "kind"),
+		logins:            registry.NewCounterVec("chirpy_logins_total", "Login attempts by result.", "result"),
+		webhookEvents:     registry.NewCounterVec("chirpy_webhook_events_total", "Incoming webhook events by provider and result.", "provider", "result"),
+		webhookDeliveries: registry.NewCounterVec("chirpy_webhook_deliveries_total", "Outbound webhook delivery attempts by result.", "result"),
+	}
+}
+
+// metricsHandler serves Prometheus metrics. Scrapers authenticate like the
+// other admin endpoints, with "Authorization: ApiKey <ADMIN_API_KEY>".
+func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	cfg.metrics.registry.Handler().ServeHTTP(w, r)
+}
+
+// middlewareInstrument counts and times every request, labeled with the
+// ServeMux pattern that matched rather than the raw path, so IDs in URLs
+// don't turn into a new series each.
+func (cfg *apiConfig) middlewareInstrument(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := routePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := methodLabel(r.Method)
+		cfg.metrics.httpRequests.WithLabelValues(method, route, strconv.Itoa(recorder.status)).Inc()
+		cfg.metrics.httpDurations.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
 	})
 }
+
+// methodLabel is the method as a label value. Clients can send any token as
+// a method, so everything but the standard ones is "OTHER".
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// routePattern is the path part of the pattern the mux matched, e.g.
+// "/api/chirps/{chirpID}", or "" when nothing matched.
+func routePattern(r *http.Request) string {
+	if r.Pattern == "" {
+		return ""
+	}
+	if _, path, found := strings.Cut(r.Pattern, " "); found {
+		return path
+	}
+	return r.Pattern
+}
+
+// statusRecorder remembers the status code and body size written. It passes flushes and
+// hijacks through so Server-Sent Events and WebSockets keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	s.wroteHeader = true
+	return hijacker.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
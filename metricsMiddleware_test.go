@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Made-up methods on unmatched routes must not create a series each.
+func TestInstrumentBoundsMethodLabel(t *testing.T) {
+	cfg := &apiConfig{metrics: newAppMetrics()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/healthz", healthHandler)
+	handler := cfg.middlewareInstrument(mux)
+
+	for _, method := range []string{"GET", "DELETE", "FOO", "BAR", "get"} {
+		req := httptest.NewRequest(method, "/api/healthz", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	buf := &bytes.Buffer{}
+	cfg.metrics.registry.WriteTo(buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		`chirpy_http_requests_total{method="GET",route="/api/healthz",code="200"} 1`,
+		`chirpy_http_requests_total{method="DELETE",route="unmatched",code="405"} 1`,
+		`chirpy_http_requests_total{method="OTHER",route="unmatched",code="405"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, unwanted := range []string{`method="FOO"`, `method="BAR"`, `method="get"`} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("found %s", unwanted)
+		}
+	}
+}
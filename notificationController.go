@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/chirptext"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/google/uuid"
+)
+
+const (
+	notificationTypeRechirp = "rechirp"
+	notificationTypeQuote   = "quote"
+	notificationTypeMention = "mention"
+
+	// mentions past this many in one chirp don't notify anyone, so a chirp
+	// can't be used to page a list of users
+	maxMentionsPerChirp = 10
+
+	// how many of the people behind an aggregated notification are listed
+	maxNotificationActors = 3
+
+	defaultNotificationsPageSize = 20
+	maxNotificationsPageSize     = 100
+)
+
+var notificationTypes = []string{
+	notificationTypeRechirp,
+	notificationTypeQuote,
+	notificationTypeMention,
+}
+
+type NotificationDTO struct {
+	ID      uuid.UUID `json:"id"`
+	Type    string    `json:"type"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+	// the most recent actors first; actor_count says how many there are in
+	// total, as in "alice, bob and 3 others rechirped your chirp"
+	ActorIDs   []uuid.UUID `json:"actor_ids"`
+	ActorCount int         `json:"actor_count"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Read       bool        `json:"read"`
+}
+
+type NotificationsDTO struct {
+	UnreadCount   int64             `json:"unread_count"`
+	Notifications []NotificationDTO `json:"notifications"`
+}
+
+// notifyChirpCreated is the event bus subscriber that tells authors about
+// rechirps and quotes of their chirps, and users about chirps mentioning
+// them.
+func (cfg *apiConfig) notifyChirpCreated(ctx context.Context, event events.Event) error {
+	payload := chirpEventPayload{}
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.notifyMentions(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	var notificationType string
+	switch payload.Kind {
+	case chirpKindRechirp:
+		notificationType = notificationTypeRechirp
+	case chirpKindQuote:
+		notificationType = notificationTypeQuote
+	default:
+		return nil
+	}
+	if payload.OriginalChirpID == nil {
+		return nil
+	}
+
+	original, err := cfg.db.GetChirp(ctx, *payload.OriginalChirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// deleted before we got to it, nothing left to point at
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return cfg.notify(ctx, original.UserID, payload.UserID, notificationType, original.ID)
+}
+
+// notifyMentions tells the users a chirp or quote mentions as
+// "@<email>" about it. Addresses that aren't a user's are skipped.
+func (cfg *apiConfig) notifyMentions(ctx context.Context, payload chirpEventPayload) error {
+	if payload.Kind == chirpKindRechirp {
+		// the mentions belong to the original, which already notified
+		return nil
+	}
+
+	mentions := chirptext.Mentions(payload.Body)
+	if len(mentions) > maxMentionsPerChirp {
+		mentions = mentions[:maxMentionsPerChirp]
+	}
+
+	for _, email := range mentions {
+		user, err := cfg.db.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cfg.notify(ctx, user.ID, payload.UserID, notificationTypeMention, payload.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notify records that actor did something of the given type to recipient's
+// chirp, unless recipient turned that type off or blocked or muted actor.
+func (cfg *apiConfig) notify(ctx context.Context, recipientID, actorID uuid.UUID, notificationType string, chirpID uuid.UUID) error {
+	if recipientID == actorID {
+		return nil
+	}
+
+	preferences, err := cfg.notificationPreferences(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+	if !preferences[notificationType] {
+		return nil
+	}
+
+	blocked, err := cfg.db.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+		UserA: recipientID,
+		UserB: actorID,
+	})
+	if err != nil || blocked {
+		return err
+	}
+
+	muted, err := cfg.db.IsMuted(ctx, database.IsMutedParams{
+		MuterID: recipientID,
+		MutedID: actorID,
+	})
+	if err != nil || muted {
+		return err
+	}
+
+	notification, err := cfg.db.UpsertNotification(ctx, database.UpsertNotificationParams{
+		RecipientID: recipientID,
+		Type:        notificationType,
+		ChirpID:     chirpID,
+		ActorID:     actorID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// the actor was already part of it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// live delivery is best effort, the inbox has it either way
+	payload, err := json.Marshal(notificationStreamNotification{
+		RecipientID:  recipientID,
+		Notification: notificationToDTO(notification),
+	})
+	if err == nil {
+		err = cfg.db.NotifyNotificationStream(ctx, string(payload))
+	}
+	if err != nil {
+		slog.Error("Error announcing notification", "notification_id", notification.ID, "error", err)
+	}
+
+	return nil
+}
+
+func notificationToDTO(notification database.Notification) NotificationDTO {
+	actorIDs := make([]uuid.UUID, 0, maxNotificationActors)
+	for i := len(notification.ActorIds) - 1; i >= 0 && len(actorIDs) < maxNotificationActors; i-- {
+		actorIDs = append(actorIDs, notification.ActorIds[i])
+	}
+
+	return NotificationDTO{
+		ID:         notification.ID,
+		Type:       notification.Type,
+		ChirpID:    notification.ChirpID,
+		ActorIDs:   actorIDs,
+		ActorCount: len(notification.ActorIds),
+		CreatedAt:  notification.CreatedAt,
+		UpdatedAt:  notification.UpdatedAt,
+		Read:       notification.ReadAt.Valid,
+	}
+}
+
+// notificationPreferences returns whether each notification type is on for
+// the user. Types are on until the user turns them off.
+func (cfg *apiConfig) notificationPreferences(ctx context.Context, userID uuid.UUID) (map[string]bool, error) {
+	rows, err := cfg.db.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := make(map[string]bool, len(notificationTypes))
+	for _, notificationType := range notificationTypes {
+		preferences[notificationType] = true
+	}
+	for _, row := range rows {
+		if _, ok := preferences[row.Type]; ok {
+			preferences[row.Type] = row.Enabled
+		}
+	}
+
+	return preferences, nil
+}
+
+// getNotificationsHandler lists the caller's notifications, most recently
+// active first, with ?limit= and ?offset= paging. ?unread=true leaves out
+// the ones already read.
+func (cfg *apiConfig) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultNotificationsPageSize, maxNotificationsPageSize)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	notifications, err := cfg.db.GetNotificationsByUser(r.Context(), database.GetNotificationsByUserParams{
+		RecipientID: userID,
+		UnreadOnly:  r.URL.Query().Get("unread") == "true",
+		PageLimit:   limit,
+		PageOffset:  offset,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving notifications")
+		return
+	}
+
+	unreadCount, err := cfg.db.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving notifications")
+		return
+	}
+
+	respBody := NotificationsDTO{
+		UnreadCount:   unreadCount,
+		Notifications: make([]NotificationDTO, 0, len(notifications)),
+	}
+	for _, notification := range notifications {
+		respBody.Notifications = append(respBody.Notifications, notificationToDTO(notification))
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+// markNotificationsReadHandler marks the given notifications as read, or
+// all of them when the body names none. Whatever happens next starts a new
+// notification instead of joining a read one.
+func (cfg *apiConfig) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	type parameters struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if len(params.IDs) == 0 {
+		err = cfg.db.MarkAllNotificationsRead(r.Context(), userID)
+	} else {
+		err = cfg.db.MarkNotificationsRead(r.Context(), database.MarkNotificationsReadParams{
+			RecipientID: userID,
+			Ids:         params.IDs,
+		})
+	}
+	if err != nil {
+		respondWithError(w, 400, "Error updating notifications")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	preferences, err := cfg.notificationPreferences(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving preferences")
+		return
+	}
+
+	respondWithJSON(w, 200, preferences)
+}
+
+// updateNotificationPreferencesHandler takes a {"<type>": <enabled>} object.
+// Types left out keep their current setting.
+func (cfg *apiConfig) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := map[string]bool{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	preferences, err := cfg.notificationPreferences(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 400, "Error updating preferences")
+		return
+	}
+
+	for notificationType := range params {
+		if _, ok := preferences[notificationType]; !ok {
+			respondWithError(w, 400, "Unknown notification type "+notificationType)
+			return
+		}
+	}
+
+	for notificationType, enabled := range params {
+		err = cfg.db.UpsertNotificationPreference(r.Context(), database.UpsertNotificationPreferenceParams{
+			UserID:  userID,
+			Type:    notificationType,
+			Enabled: enabled,
+		})
+		if err != nil {
+			respondWithError(w, 400, "Error updating preferences")
+			return
+		}
+		preferences[notificationType] = enabled
+	}
+
+	respondWithJSON(w, 200, preferences)
+}
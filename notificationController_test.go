@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/google/uuid"
+)
+
+var notificationColumns = []string{"id", "created_at", "updated_at", "recipient_id", "type", "chirp_id", "actor_ids", "read_at"}
+
+func TestNotifyMentions(t *testing.T) {
+	authorID, mentionedID, chirpID := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		kind       string
+		body       string
+		user       uuid.UUID
+		wantLookup string
+		notified   bool
+	}{
+		{"chirp", chirpKindChirp, "hey @bob@example.com", mentionedID, "bob@example.com", true},
+		{"quote", chirpKindQuote, "look @bob@example.com", mentionedID, "bob@example.com", true},
+		{"no such user", chirpKindChirp, "hey @nobody@example.com", uuid.Nil, "nobody@example.com", false},
+		{"mentioning yourself", chirpKindChirp, "note to @me@example.com", authorID, "me@example.com", false},
+		{"plain address", chirpKindChirp, "mail bob@example.com", mentionedID, "", false},
+		{"rechirp", chirpKindRechirp, "", mentionedID, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, conn := newFakeDB(t)
+			if tt.user == uuid.Nil {
+				fake.returns("GetUserByEmail", userColumns)
+			} else {
+				fake.returns("GetUserByEmail", userColumns, []driver.Value{tt.user.String(), now, now, tt.wantLookup, "hash", false, nil, nil})
+			}
+			fake.returns("GetNotificationPreferences", []string{"user_id", "type", "enabled"})
+			fake.returns("IsBlockedEitherWay", []string{"exists"}, []driver.Value{false})
+			fake.returns("IsMuted", []string{"exists"}, []driver.Value{false})
+			fake.returns("UpsertNotification", notificationColumns, []driver.Value{uuid.NewString(), now, now, mentionedID.String(), notificationTypeMention, chirpID.String(), []byte("{" + authorID.String() + "}"), nil})
+			fake.returns("NotifyNotificationStream", nil)
+			// a rechirp has no original here, so only mentions can notify
+			fake.returns("GetChirp", chirpColumns)
+			cfg := &apiConfig{db: database.New(conn)}
+
+			original := uuid.New()
+			payload, err := json.Marshal(chirpEventPayload{ID: chirpID, UserID: authorID, Body: tt.body, Kind: tt.kind, OriginalChirpID: &original})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.notifyChirpCreated(context.Background(), events.Event{Type: eventChirpCreated, Payload: payload})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantLookup == "" {
+				if fake.didRun("GetUserByEmail") {
+					t.Error("looked up a user")
+				}
+			} else if args := fake.argsOf("GetUserByEmail"); len(args) != 1 || args[0] != tt.wantLookup {
+				t.Errorf("GetUserByEmail args = %v", args)
+			}
+
+			if !tt.notified {
+				if fake.didRun("UpsertNotification") {
+					t.Error("notified")
+				}
+				return
+			}
+			// recipient_id, type, chirp_id, actor_id
+			args := fake.argsOf("UpsertNotification")
+			want := []any{tt.user.String(), notificationTypeMention, chirpID.String(), authorID.String()}
+			if len(args) != 4 || args[0] != want[0] || args[1] != want[1] || args[2] != want[2] || args[3] != want[3] {
+				t.Errorf("UpsertNotification args = %v, want %v", args, want)
+			}
+		})
+	}
+}
+
+// Mentions past maxMentionsPerChirp don't notify.
+func TestNotifyMentionsCapped(t *testing.T) {
+	fake, conn := newFakeDB(t)
+	fake.returns("GetUserByEmail", userColumns)
+	cfg := &apiConfig{db: database.New(conn)}
+
+	body := ""
+	for i := range maxMentionsPerChirp + 5 {
+		body += " @user" + string(rune('a'+i)) + "@example.com"
+	}
+	err := cfg.notifyMentions(context.Background(), chirpEventPayload{ID: uuid.New(), UserID: uuid.New(), Body: body, Kind: chirpKindChirp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := "user" + string(rune('a'+maxMentionsPerChirp-1)) + "@example.com"
+	if args := fake.argsOf("GetUserByEmail"); len(args) != 1 || args[0] != last {
+		t.Errorf("last lookup = %v, want %s", args, last)
+	}
+}
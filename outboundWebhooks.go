@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/events"
+	"github.com/gaschneider/go/httpserver/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	// dead deliveries ran out of attempts and wait for a manual retry
+	webhookDeliveryDead = "dead"
+
+	maxWebhookAttempts       = 10
+	webhookDispatchBatchSize = 50
+	webhookDispatchWorkers   = 8
+	// long enough for a whole batch to go out before other instances may
+	// pick the same deliveries up again
+	webhookLeaseSeconds     = 300
+	webhookDispatchInterval = 5 * time.Second
+)
+
+var outboundEventTypes = map[string]bool{
+	eventUserCreated:    true,
+	eventChirpCreated:   true,
+	eventChirpDeleted:   true,
+	eventUserUpgraded:   true,
+	eventUserDowngraded: true,
+}
+
+// webhookEnvelope is the body subscribers receive.
+type webhookEnvelope struct {
+	EventID   uuid.UUID       `json:"event_id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// enqueueWebhookDeliveries is the event bus subscriber that fans domain
+// events out to the outbox of webhook deliveries.
+func (cfg *apiConfig) enqueueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(webhookEnvelope{
+		EventID:   event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.db.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventType: event.Type,
+		Payload:   string(payload),
+		UserID:    event.UserID,
+	})
+}
+
+// runWebhookDispatcher sends due deliveries from the outbox. Deliveries are
+// at least once: a subscriber can tell repeats apart by the delivery header.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.dispatchWebhooks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) dispatchWebhooks(ctx context.Context) {
+	for {
+		deliveries, err := cfg.db.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+			LeaseSeconds: webhookLeaseSeconds,
+			BatchSize:    webhookDispatchBatchSize,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error claiming webhook deliveries", "error", err)
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, webhookDispatchWorkers)
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(delivery database.WebhookDelivery) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				cfg.deliverWebhook(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookDispatchBatchSize {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, delivery database.WebhookDelivery) {
+	subscription, err := cfg.db.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// deleted in the meantime, its deliveries went with it
+		return
+	}
+	if err != nil {
+		slog.Error("Error loading webhook subscription", "subscription_id", delivery.SubscriptionID, "delivery_id", delivery.ID, "error", err)
+		return
+	}
+
+	// only admins may point webhooks inside our network
+	sender := cfg.publicWebhookSender
+	if !subscription.UserID.Valid {
+		sender = cfg.webhookSender
+	}
+
+	started := time.Now()
+	statusCode, sendErr := sender.Send(ctx, webhook.Delivery{
+		ID:     delivery.ID.String(),
+		Event:  delivery.EventType,
+		URL:    subscription.Url,
+		Secret: subscription.Secret,
+		Body:   []byte(delivery.Payload),
+	})
+	if ctx.Err() != nil {
+		// shutting down; the lease runs out and the delivery is retried
+		return
+	}
+
+	attempt := database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID:  delivery.ID,
+		AttemptedAt: started.UTC(),
+		DurationMs:  int32(time.Since(started).Milliseconds()),
+	}
+	if statusCode != 0 {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+	}
+	if sendErr != nil {
+		attempt.Error = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	err = cfg.db.CreateWebhookDeliveryAttempt(ctx, attempt)
+	if err != nil {
+		slog.Error("Error recording webhook delivery attempt", "delivery_id", delivery.ID, "error", err)
+	}
+
+	if sendErr == nil {
+		cfg.metrics.webhookDeliveries.WithLabelValues("success").Inc()
+		err = cfg.db.MarkWebhookDeliveryDelivered(ctx, delivery.ID)
+	} else {
+		cfg.metrics.webhookDeliveries.WithLabelValues("failure").Inc()
+		attempts := int(delivery.Attempts) + 1
+		params := database.MarkWebhookDeliveryFailedParams{
+			ID:            delivery.ID,
+			Status:        webhookDeliveryPending,
+			NextAttemptAt: time.Now().UTC().Add(webhook.Backoff(attempts)),
+		}
+		if attempts >= maxWebhookAttempts {
+			params.Status = webhookDeliveryDead
+			params.NextAttemptAt = time.Now().UTC()
+		}
+		err = cfg.db.MarkWebhookDeliveryFailed(ctx, params)
+	}
+	if err != nil {
+		slog.Error("Error updating webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
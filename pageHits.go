@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+)
+
+const (
+	pageHitFlushInterval = 10 * time.Second
+	// how long the last flush may take while shutting down
+	pageHitFinalFlushTimeout = 5 * time.Second
+	pageHitDayFormat         = "2006-01-02"
+)
+
+type pageHitKey struct {
+	path string
+	day  string
+}
+
+// pageHitCounter buffers hits in memory between flushes to Postgres, where
+// every instance adds its counts to the same rows.
+type pageHitCounter struct {
+	mu      sync.Mutex
+	pending map[pageHitKey]int64
+}
+
+func newPageHitCounter() *pageHitCounter {
+	return &pageHitCounter{pending: make(map[pageHitKey]int64)}
+}
+
+func (c *pageHitCounter) add(path string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending[pageHitKey{path: path, day: at.UTC().Format(pageHitDayFormat)}]++
+}
+
+// take hands the buffered hits to a flush and starts a new buffer.
+func (c *pageHitCounter) take() map[pageHitKey]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.pending
+	c.pending = make(map[pageHitKey]int64)
+	return pending
+}
+
+// restore puts back hits a flush failed to write, so the next one retries.
+func (c *pageHitCounter) restore(hits map[pageHitKey]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, count := range hits {
+		c.pending[key] += count
+	}
+}
+
+func (c *pageHitCounter) pendingTotal() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	for _, count := range c.pending {
+		total += count
+	}
+	return total
+}
+
+func (c *pageHitCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending = make(map[pageHitKey]int64)
+}
+
+// middlewareMetricsInc counts hits on the file server. Only responses for
+// files that exist count, 2xx and 304, so requests for made-up paths can't
+// fill the table. Redirects don't: http.FileServer redirects any
+// ".../index.html" before checking the file is there.
+func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if (recorder.status >= 200 && recorder.status < 300) || recorder.status == http.StatusNotModified {
+			cfg.pageHits.add(r.URL.Path, time.Now())
+		}
+	})
+}
+
+func (cfg *apiConfig) runPageHitFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// don't lose what came in since the last tick
+			flushCtx, cancel := context.WithTimeout(context.Background(), pageHitFinalFlushTimeout)
+			defer cancel()
+			cfg.flushPageHits(flushCtx)
+			return
+		case <-ticker.C:
+			cfg.flushPageHits(ctx)
+		}
+	}
+}
+
+func (cfg *apiConfig) flushPageHits(ctx context.Context) {
+	hits := cfg.pageHits.take()
+	if len(hits) == 0 {
+		return
+	}
+
+	params := database.AddPageHitsParams{}
+	for key, count := range hits {
+		params.Paths = append(params.Paths, key.path)
+		params.Days = append(params.Days, key.day)
+		params.Hits = append(params.Hits, count)
+	}
+
+	err := cfg.db.AddPageHits(ctx, params)
+	if err != nil {
+		slog.Error("Error flushing page hits", "paths", len(params.Paths), "error", err)
+		cfg.pageHits.restore(hits)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+)
+
+func TestPageHitsCountOnlyExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>hi</h1>"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &apiConfig{pageHits: newPageHitCounter()}
+	handler := cfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(dir))))
+
+	tests := []struct {
+		path    string
+		counted bool
+	}{
+		{"/app/", true},
+		{"/app/missing.html", false},
+		// FileServer redirects these before looking for the file
+		{"/app/made-up/index.html", false},
+		{"/app/index.html", false},
+	}
+
+	for _, tt := range tests {
+		before := cfg.pageHits.pendingTotal()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+		counted := cfg.pageHits.pendingTotal() > before
+		if counted != tt.counted {
+			t.Errorf("%s counted = %v, want %v", tt.path, counted, tt.counted)
+		}
+	}
+}
+
+// Both the report and the reset are admin endpoints.
+func TestPageHitsAdminOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform string
+		adminKey string
+		header   string
+		want     int
+	}{
+		{"no key configured outside dev", "prod", "", "", 403},
+		{"no key configured in dev", "dev", "", "", 200},
+		{"key missing", "prod", "s3cret", "", 403},
+		{"wrong key", "prod", "s3cret", "ApiKey nope", 403},
+		{"bearer instead of key", "prod", "s3cret", "Bearer s3cret", 403},
+		{"right key", "prod", "s3cret", "ApiKey s3cret", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, conn := newFakeDB(t)
+			fake.returns("GetTotalPageHits", []string{"hits"}, []driver.Value{int64(7)})
+			fake.returns("ListPageHitsByPath", []string{"path", "hits"})
+			fake.returns("ListPageHitsByDay", []string{"day", "hits"})
+			fake.returns("ResetPageHits", nil)
+			cfg := &apiConfig{
+				db:       database.New(conn),
+				pageHits: newPageHitCounter(),
+				platform: tt.platform,
+				adminKey: tt.adminKey,
+			}
+
+			for _, route := range []struct {
+				method, path string
+				handler      http.HandlerFunc
+				query        string
+			}{
+				{"GET", "/admin/metrics", cfg.displayCountRequestsHandler, "GetTotalPageHits"},
+				{"POST", "/admin/reset", cfg.resetCountRequestsHandler, "ResetPageHits"},
+			} {
+				req := httptest.NewRequest(route.method, route.path, nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				route.handler(rec, req)
+
+				if rec.Code != tt.want {
+					t.Errorf("%s %s = %d, want %d", route.method, route.path, rec.Code, tt.want)
+				}
+				if tt.want == 403 && (fake.didRun(route.query) || !strings.Contains(rec.Body.String(), `"error"`)) {
+					t.Errorf("%s %s: ran %s = %v, body %s", route.method, route.path, route.query, fake.didRun(route.query), rec.Body)
+				}
+			}
+		})
+	}
+}
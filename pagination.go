@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// parsePagination reads ?limit= and ?offset= for the endpoints that page
+// through results.
+func parsePagination(r *http.Request, defaultLimit, maxLimit int32) (int32, int32, error) {
+	limit := defaultLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.ParseInt(rawLimit, 10, 32)
+		if err != nil || parsed < 1 || int32(parsed) > maxLimit {
+			return 0, 0, errors.New("Invalid limit")
+		}
+		limit = int32(parsed)
+	}
+
+	var offset int32
+	if rawOffset := r.URL.Query().Get("offset"); rawOffset != "" {
+		parsed, err := strconv.ParseInt(rawOffset, 10, 32)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("Invalid offset")
+		}
+		offset = int32(parsed)
+	}
+
+	return limit, offset, nil
+}
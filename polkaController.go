@@ -1,58 +1,239 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gaschneider/go/httpserver/internal/auth"
 	"github.com/gaschneider/go/httpserver/internal/database"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlePolkaEvents(w http.ResponseWriter, r *http.Request) {
-	apiKey, err := auth.GetAPIKey(r.Header)
-	if err != nil || apiKey != cfg.polkaKey {
-		respondWithError(w, 401, "Unauthorized")
-		return
+const (
+	polkaTimestampHeader = "X-Polka-Timestamp"
+	polkaSignatureHeader = "X-Polka-Signature"
+	polkaEventIDHeader   = "X-Polka-Event-Id"
+	maxPolkaBodyBytes    = 1 << 20
+	// a pending event older than this is assumed abandoned rather than in
+	// progress; LockWebhookEvent keeps it from being applied twice either way
+	polkaPendingStaleAfter = time.Minute
+)
+
+// authenticatePolka checks that a webhook really comes from Polka. When
+// signing secrets are configured the body must carry a valid HMAC signature;
+// otherwise it falls back to the static API key, which main warns about.
+func (cfg *apiConfig) authenticatePolka(r *http.Request, body []byte) error {
+	if len(cfg.polkaSigningSecrets) > 0 {
+		return auth.VerifySignature(
+			cfg.polkaSigningSecrets,
+			r.Header.Get(polkaTimestampHeader),
+			r.Header.Get(polkaSignatureHeader),
+			body,
+			cfg.polkaSignatureTolerance,
+		)
 	}
 
-	type bodyData struct {
-		UserId uuid.UUID `json:"user_id"`
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return err
 	}
-	type body struct {
-		Event string   `json:"event"`
-		Data  bodyData `json:"data"`
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		return errors.New("invalid key")
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := body{}
-	err = decoder.Decode(&params)
+	return nil
+}
+
+func (cfg *apiConfig) handlePolkaEvents(w http.ResponseWriter, r *http.Request) {
+	// the signature covers the raw bytes, so read them before decoding
+	rawBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolkaBodyBytes))
+	if _, ok := err.(*http.MaxBytesError); ok {
+		respondWithError(w, 413, "Request body too large")
+		return
+	}
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		requestLogger(r.Context()).Error("Error reading webhook body", "error", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	if params.Event != "user.upgraded" {
-		w.WriteHeader(204)
+	err = cfg.authenticatePolka(r, rawBody)
+	if err != nil {
+		cfg.countPolkaEvent("unauthorized")
+		respondWithError(w, 401, "Unauthorized")
 		return
 	}
 
-	_, err = cfg.db.GetUser(r.Context(), params.Data.UserId)
+	params := polkaEvent{}
+	err = json.Unmarshal(rawBody, &params)
 	if err != nil {
-		respondWithError(w, 404, "User not found")
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	_, err = cfg.db.UpdateUserChirpyRed(r.Context(), database.UpdateUserChirpyRedParams{
-		IsChirpyRed: true,
-		ID:          params.Data.UserId,
+	eventID := cfg.polkaEventID(r, params, rawBody)
+	event, err := cfg.db.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		Provider:  webhookProviderPolka,
+		EventID:   eventID,
+		EventType: params.Event,
+		Payload:   string(rawBody),
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		event, err = cfg.db.GetWebhookEventByEventId(r.Context(), database.GetWebhookEventByEventIdParams{
+			Provider: webhookProviderPolka,
+			EventID:  eventID,
+		})
+		if err != nil {
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		// a redelivery of something we already handled (or are handling right
+		// now); events that failed earlier get another go, and so do ones
+		// left pending too long, e.g. by a crash halfway through
+		stalePending := event.Status == webhookEventPending && time.Since(event.ReceivedAt) > polkaPendingStaleAfter
+		if event.Status != webhookEventFailed && !stalePending {
+			cfg.countPolkaEvent("duplicate")
+			w.WriteHeader(204)
+			return
+		}
+	} else if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	err = cfg.processPolkaEvent(r.Context(), event)
+	if errors.Is(err, errWebhookUserNotFound) {
+		cfg.countPolkaEvent("user_not_found")
+		respondWithError(w, 404, "User not found")
+		return
+	}
 	if err != nil {
+		cfg.countPolkaEvent("failed")
 		respondWithError(w, 400, "Error updating user")
 		return
 	}
 
+	cfg.countPolkaEvent("processed")
 	w.WriteHeader(204)
 }
+
+func (cfg *apiConfig) countPolkaEvent(result string) {
+	cfg.metrics.webhookEvents.WithLabelValues(webhookProviderPolka, result).Inc()
+}
+
+type polkaEvent struct {
+	// not every Polka integration sends an ID, see polkaEventID
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserId uuid.UUID `json:"user_id"`
+	} `json:"data"`
+}
+
+// polkaEventID is the key duplicate deliveries are detected by. Without an
+// explicit ID a signed delivery falls back to a hash of its timestamp and
+// body, which is the same for a retry but differs between two events.
+//
+// An unsigned delivery without an ID has nothing but its content to go by, so
+// the event, the user and the body are hashed. A retry then counts as the
+// same event and can't extend the subscription a second time; the price is
+// that two identical unsigned deliveries count as one, which is why signing
+// or sending an ID is preferred.
+func (cfg *apiConfig) polkaEventID(r *http.Request, event polkaEvent, rawBody []byte) string {
+	if event.ID != "" {
+		return event.ID
+	}
+	if id := r.Header.Get(polkaEventIDHeader); id != "" {
+		return id
+	}
+
+	hash := sha256.New()
+	if len(cfg.polkaSigningSecrets) == 0 {
+		io.WriteString(hash, event.Event+"\x00"+event.Data.UserId.String()+"\x00")
+		hash.Write(rawBody)
+		return "unsigned:sha256:" + hex.EncodeToString(hash.Sum(nil))
+	}
+
+	io.WriteString(hash, r.Header.Get(polkaTimestampHeader))
+	hash.Write(rawBody)
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// processPolkaEvent applies a stored Polka event and records the outcome on
+// it. The subscription change and the event's status are committed together,
+// so an event is applied at most once however often it's delivered.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, event database.WebhookEvent) error {
+	err := cfg.applyPolkaEvent(ctx, event)
+	if err != nil {
+		markErr := cfg.db.MarkWebhookEventFailed(ctx, database.MarkWebhookEventFailedParams{
+			ID:        event.ID,
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+		})
+		if markErr != nil {
+			slog.Error("Error marking webhook event as failed", "event_id", event.ID, "error", markErr)
+		}
+	}
+
+	return err
+}
+
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, event database.WebhookEvent) error {
+	params := polkaEvent{}
+	err := json.Unmarshal([]byte(event.Payload), &params)
+	if err != nil {
+		return err
+	}
+
+	status := webhookEventProcessed
+	if !handledPolkaEvents[params.Event] {
+		status = webhookEventIgnored
+	} else {
+		_, err = cfg.db.GetUser(ctx, params.Data.UserId)
+		if err != nil {
+			return errWebhookUserNotFound
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	locked, err := qtx.LockWebhookEvent(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	if locked.Status == webhookEventProcessed || locked.Status == webhookEventIgnored {
+		return nil
+	}
+
+	if status == webhookEventProcessed {
+		err = applySubscriptionEvent(ctx, qtx, params.Data.UserId, params.Event)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = qtx.MarkWebhookEventDone(ctx, database.MarkWebhookEventDoneParams{
+		ID:     event.ID,
+		Status: status,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolkaEventID(t *testing.T) {
+	const (
+		upgradeA = `{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`
+		upgradeB = `{"event":"user.upgraded","data":{"user_id":"d1e7a8f4-2b7c-4a0e-9c1a-5b8e6f3d2c10"}}`
+		renewA   = `{"event":"user.renewed","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`
+		withID   = `{"id":"evt_123","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`
+	)
+
+	id := func(t *testing.T, cfg *apiConfig, body, timestamp, header string) string {
+		t.Helper()
+		event := polkaEvent{}
+		err := json.Unmarshal([]byte(body), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+		if timestamp != "" {
+			req.Header.Set(polkaTimestampHeader, timestamp)
+		}
+		if header != "" {
+			req.Header.Set(polkaEventIDHeader, header)
+		}
+		return cfg.polkaEventID(req, event, []byte(body))
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		cfg := &apiConfig{}
+		// a retry has to land on the same key or it would extend the
+		// subscription again
+		if id(t, cfg, upgradeA, "", "") != id(t, cfg, upgradeA, "", "") {
+			t.Error("a retry got a new key")
+		}
+		if id(t, cfg, upgradeA, "", "") == id(t, cfg, upgradeB, "", "") {
+			t.Error("two users share a key")
+		}
+		if id(t, cfg, upgradeA, "", "") == id(t, cfg, renewA, "", "") {
+			t.Error("two events share a key")
+		}
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		cfg := &apiConfig{polkaSigningSecrets: []string{"whsec_current"}}
+		if id(t, cfg, upgradeA, "1700000000", "") != id(t, cfg, upgradeA, "1700000000", "") {
+			t.Error("a retry got a new key")
+		}
+		if id(t, cfg, upgradeA, "1700000000", "") == id(t, cfg, upgradeA, "1702592000", "") {
+			t.Error("a later event shares the key")
+		}
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		cfg := &apiConfig{}
+		if got := id(t, cfg, withID, "", "evt_header"); got != "evt_123" {
+			t.Errorf("body ID not used: %q", got)
+		}
+		if got := id(t, cfg, upgradeA, "", "evt_header"); got != "evt_header" {
+			t.Errorf("header ID not used: %q", got)
+		}
+	})
+}
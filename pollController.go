@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	minPollOptions     = 2
+	maxPollOptions     = 4
+	maxPollOptionChars = 25
+	minPollDuration    = 5 * time.Minute
+	maxPollDuration    = 7 * 24 * time.Hour
+)
+
+type pollParameters struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+type PollOptionDTO struct {
+	ID    uuid.UUID `json:"id"`
+	Label string    `json:"label"`
+	// nil while the results are hidden from the viewer
+	Votes *int64 `json:"votes"`
+}
+
+type PollDTO struct {
+	ID       uuid.UUID       `json:"id"`
+	ClosesAt time.Time       `json:"closes_at"`
+	Closed   bool            `json:"closed"`
+	Options  []PollOptionDTO `json:"options"`
+	// results are only shown once the viewer voted or the poll closed
+	ResultsVisible bool       `json:"results_visible"`
+	TotalVotes     *int64     `json:"total_votes"`
+	VotedOptionID  *uuid.UUID `json:"voted_option_id,omitempty"`
+}
+
+// validatePoll checks a poll attached to a new chirp. opensAt is when the
+// chirp goes public, which is later than now for scheduled chirps.
+func validatePoll(params pollParameters, opensAt time.Time) ([]string, error) {
+	if len(params.Options) < minPollOptions || len(params.Options) > maxPollOptions {
+		return nil, errors.New("Polls need between 2 and 4 options")
+	}
+
+	options := make([]string, 0, len(params.Options))
+	for _, option := range params.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || len([]rune(option)) > maxPollOptionChars {
+			return nil, errors.New("Poll options must be between 1 and 25 characters")
+		}
+		options = append(options, option)
+	}
+
+	if params.ClosesAt.Before(opensAt.Add(minPollDuration)) {
+		return nil, errors.New("Polls must stay open for at least 5 minutes")
+	}
+	if params.ClosesAt.After(opensAt.Add(maxPollDuration)) {
+		return nil, errors.New("Polls can't stay open for more than 7 days")
+	}
+
+	return options, nil
+}
+
+func createPoll(ctx context.Context, qtx *database.Queries, chirpID uuid.UUID, options []string, closesAt time.Time) error {
+	poll, err := qtx.CreatePoll(ctx, database.CreatePollParams{
+		ChirpID:  chirpID,
+		ClosesAt: closesAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, label := range options {
+		_, err = qtx.CreatePollOption(ctx, database.CreatePollOptionParams{
+			PollID:   poll.ID,
+			Position: int32(i),
+			Label:    label,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadPolls returns the polls attached to the given chirps keyed by chirp ID,
+// with the tallies filled in only where the viewer is allowed to see them.
+func (cfg *apiConfig) loadPolls(ctx context.Context, chirpIDs []uuid.UUID, viewerID uuid.NullUUID) (map[uuid.UUID]*PollDTO, error) {
+	polls, err := cfg.db.GetPollsByChirpIds(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	pollsByChirp := make(map[uuid.UUID]*PollDTO, len(polls))
+	if len(polls) == 0 {
+		return pollsByChirp, nil
+	}
+
+	pollIDs := make([]uuid.UUID, 0, len(polls))
+	for _, poll := range polls {
+		pollIDs = append(pollIDs, poll.ID)
+	}
+
+	options, err := cfg.db.GetPollOptionsWithVotes(ctx, pollIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	votedFor := make(map[uuid.UUID]uuid.UUID)
+	if viewerID.Valid {
+		votes, err := cfg.db.GetPollVotesByUser(ctx, database.GetPollVotesByUserParams{
+			UserID:  viewerID.UUID,
+			PollIds: pollIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, vote := range votes {
+			votedFor[vote.PollID] = vote.OptionID
+		}
+	}
+
+	optionsByPoll := make(map[uuid.UUID][]database.GetPollOptionsWithVotesRow)
+	for _, option := range options {
+		optionsByPoll[option.PollID] = append(optionsByPoll[option.PollID], option)
+	}
+
+	now := time.Now().UTC()
+	for _, poll := range polls {
+		dto := &PollDTO{
+			ID:       poll.ID,
+			ClosesAt: poll.ClosesAt,
+			Closed:   !poll.ClosesAt.After(now),
+			Options:  make([]PollOptionDTO, 0, len(optionsByPoll[poll.ID])),
+		}
+
+		optionID, voted := votedFor[poll.ID]
+		if voted {
+			dto.VotedOptionID = &optionID
+		}
+		dto.ResultsVisible = voted || dto.Closed
+
+		var total int64
+		for _, option := range optionsByPoll[poll.ID] {
+			optionDTO := PollOptionDTO{
+				ID:    option.ID,
+				Label: option.Label,
+			}
+			if dto.ResultsVisible {
+				votes := option.Votes
+				optionDTO.Votes = &votes
+			}
+			total += option.Votes
+			dto.Options = append(dto.Options, optionDTO)
+		}
+		if dto.ResultsVisible {
+			dto.TotalVotes = &total
+		}
+
+		pollsByChirp[poll.ChirpID] = dto
+	}
+
+	return pollsByChirp, nil
+}
+
+func (cfg *apiConfig) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	type parameters struct {
+		OptionID uuid.UUID `json:"option_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), requestedChirpID)
+	if err != nil || chirp.Status != chirpStatusPublished {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	blocked, err := cfg.db.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+		UserA: userID,
+		UserB: chirp.UserID,
+	})
+	if err != nil || blocked {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	poll, err := cfg.db.GetPollByChirpId(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, 404, "Poll not found")
+		return
+	}
+
+	_, err = cfg.db.CreatePollVote(r.Context(), database.CreatePollVoteParams{
+		UserID:   userID,
+		OptionID: params.OptionID,
+		PollID:   poll.ID,
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			respondWithError(w, 409, "Poll is closed")
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			respondWithError(w, 409, "Already voted")
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			respondWithError(w, 400, "Invalid option")
+		default:
+			respondWithError(w, 400, "Error voting")
+		}
+		return
+	}
+
+	polls, err := cfg.loadPolls(r.Context(), []uuid.UUID{chirp.ID}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving poll")
+		return
+	}
+
+	respondWithJSON(w, 201, polls[chirp.ID])
+}
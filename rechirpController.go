@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	chirpKindChirp   = "chirp"
+	chirpKindRechirp = "rechirp"
+	chirpKindQuote   = "quote"
+)
+
+func repostCountDelta(originalID uuid.UUID, kind string, delta int32) database.AdjustRepostCountsParams {
+	params := database.AdjustRepostCountsParams{ID: originalID}
+	if kind == chirpKindQuote {
+		params.QuoteDelta = delta
+	} else {
+		params.RechirpDelta = delta
+	}
+
+	return params
+}
+
+// rechirpHandler reposts someone's chirp. Without a body it's a plain rechirp,
+// with one it becomes a quote chirp carrying the user's commentary.
+func (cfg *apiConfig) rechirpHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChirpRequestBytes))
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		respondWithError(w, 413, "Request body too large")
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	original, err := cfg.db.GetChirp(r.Context(), requestedChirpID)
+	if err != nil || original.Status != chirpStatusPublished {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	kind := chirpKindRechirp
+	body := ""
+	if params.Body != "" {
+		kind = chirpKindQuote
+
+		maxLength, err := cfg.chirpLengthLimit(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized")
+			return
+		}
+
+		body, err = cleanChirpBody(params.Body, maxLength)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	// rechirping a rechirp reposts the chirp it points at, quotes can be quoted
+	if original.Kind == chirpKindRechirp {
+		if !original.OriginalChirpID.Valid {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+		original, err = cfg.db.GetChirp(r.Context(), original.OriginalChirpID.UUID)
+		if err != nil {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+	}
+
+	blocked, err := cfg.db.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+		UserA: userID,
+		UserB: original.UserID,
+	})
+	if err != nil || blocked {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	chirp, err := qtx.CreateRepost(r.Context(), database.CreateRepostParams{
+		Body:            body,
+		UserID:          userID,
+		Kind:            kind,
+		OriginalChirpID: uuid.NullUUID{UUID: original.ID, Valid: true},
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			respondWithError(w, 409, "Chirp already rechirped")
+			return
+		}
+		respondWithError(w, 400, "Error creating chirp")
+		return
+	}
+
+	err = qtx.AdjustRepostCounts(r.Context(), repostCountDelta(original.ID, kind, 1))
+	if err != nil {
+		respondWithError(w, 400, "Error creating chirp")
+		return
+	}
+
+	err = publishEvent(r.Context(), qtx, eventChirpCreated, chirp.UserID, chirpEventData(chirp))
+	if err != nil {
+		respondWithError(w, 400, "Error creating chirp")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	cfg.metrics.chirpsCreated.WithLabelValues(kind).Inc()
+
+	respBody, err := cfg.chirpsToDTOs(r.Context(), []database.Chirp{chirp}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving chirp")
+		return
+	}
+
+	respondWithJSON(w, 201, respBody[0])
+}
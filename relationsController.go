@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+// authorizeRelation resolves the caller and the {userID} target shared by the
+// block and mute endpoints. It writes the error response itself and returns
+// ok=false when the request can't go on.
+func (cfg *apiConfig) authorizeRelation(w http.ResponseWriter, r *http.Request) (userID, targetID uuid.UUID, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	userID, err = cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	targetID, err = uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user ID")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	if targetID == userID {
+		respondWithError(w, 400, "You can't do that to yourself")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	_, err = cfg.db.GetUser(r.Context(), targetID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	return userID, targetID, true
+}
+
+func (cfg *apiConfig) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.authorizeRelation(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.BlockUser(r.Context(), database.BlockUserParams{
+		BlockerID: userID,
+		BlockedID: targetID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error blocking user")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.authorizeRelation(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: userID,
+		BlockedID: targetID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error unblocking user")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.authorizeRelation(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.MuteUser(r.Context(), database.MuteUserParams{
+		MuterID: userID,
+		MutedID: targetID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error muting user")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := cfg.authorizeRelation(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnmuteUser(r.Context(), database.UnmuteUserParams{
+		MuterID: userID,
+		MutedID: targetID,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error unmuting user")
+		return
+	}
+
+	w.WriteHeader(204)
+}
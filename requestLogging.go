@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+	redactedValue      = "[REDACTED]"
+)
+
+type loggerContextKey struct{}
+
+// sensitiveLogKeys are attribute keys whose values never reach the logs,
+// compared case-insensitively. Header names count too, so request headers
+// can be logged as they are.
+var sensitiveLogKeys = map[string]bool{
+	"authorization":     true,
+	"cookie":            true,
+	"set-cookie":        true,
+	"x-polka-signature": true,
+	"password":          true,
+	"hashed_password":   true,
+	"token":             true,
+	"refresh_token":     true,
+	"secret":            true,
+}
+
+// newLogger returns a JSON logger that redacts sensitive attributes. level
+// is one of debug, info, warn and error; anything else means info.
+func newLogger(w io.Writer, level string) *slog.Logger {
+	var logLevel slog.Level
+	err := logLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactLogAttr,
+	}))
+}
+
+func redactLogAttr(_ []string, attr slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redactedValue)
+	}
+	return attr
+}
+
+// requestLogger is the logger for the request ctx belongs to, carrying its
+// request ID, or the default logger outside of requests.
+func requestLogger(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
+
+// requestID keeps the caller's X-Request-ID so logs can be followed across
+// services, unless it's missing or doesn't look like an ID.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		isIDChar := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)
+		if !isIDChar {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+// middlewareRequestLog writes one log line per request and hands handlers a
+// logger tagged with the request ID through the context.
+func (cfg *apiConfig) middlewareRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String())
+		}
+		logged := r.WithContext(context.WithValue(r.Context(), loggerContextKey{}, logger))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, logged)
+		// the mux sets the pattern on the request it was given
+		r.Pattern = logged.Pattern
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", routePattern(r),
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		}
+		// handlers validate tokens themselves; this only tags the log line
+		if token, err := auth.GetBearerToken(r.Header); err == nil {
+			if userID, err := auth.ValidateJWT(token, cfg.secret); err == nil {
+				attrs = append(attrs, "user_id", userID)
+			}
+		}
+		if logger.Enabled(r.Context(), slog.LevelDebug) {
+			attrs = append(attrs, "headers", headerLogValue(r.Header))
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+func headerLogValue(header http.Header) slog.Value {
+	attrs := make([]slog.Attr, 0, len(header))
+	for name, values := range header {
+		attrs = append(attrs, slog.String(name, strings.Join(values, ", ")))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// workerGroup runs the background jobs under one context so shutdown can
+// stop them and wait for them to finish what they're doing.
+type workerGroup struct {
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, stop := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, stop: stop}
+}
+
+func (g *workerGroup) Go(run func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		run(g.ctx)
+	}()
+}
+
+// Stop cancels the workers and waits for them until ctx is done. It
+// reports whether they all returned in time.
+func (g *workerGroup) Stop(ctx context.Context) bool {
+	g.stop()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdown winds the servers down in order:
+//  1. readyz starts failing, and the server waits drainDelay so load
+//     balancers can notice before connections are refused.
+//  2. The servers stop accepting connections and wait for in-flight
+//     requests. Streams and WebSockets are closed through the
+//     RegisterOnShutdown hooks.
+//  3. Background workers are stopped. The hit counter does its final flush.
+//  4. The link preview pool and the database pool are closed.
+//
+// Steps 2 and 3 each get up to timeout.
+func (cfg *apiConfig) shutdown(servers []*http.Server, workers *workerGroup, drainDelay, timeout time.Duration) {
+	cfg.readiness.markShuttingDown()
+	if drainDelay > 0 {
+		slog.Info("Draining before shutdown", "delay", drainDelay.String())
+		time.Sleep(drainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			slog.Warn("Requests still running at the shutdown deadline", "addr", server.Addr, "error", err)
+			server.Close()
+		}
+	}
+
+	workersCtx, cancelWorkers := context.WithTimeout(context.Background(), timeout)
+	defer cancelWorkers()
+	if !workers.Stop(workersCtx) {
+		slog.Warn("Background workers still running at the shutdown deadline")
+	}
+
+	cfg.linkPreviews.Close()
+	err := cfg.dbConn.Close()
+	if err != nil {
+		slog.Warn("Error closing database", "error", err)
+	}
+}
@@ -0,0 +1,54 @@
+-- name: UpsertBookmark :one
+-- UpsertBookmark files an existing bookmark in the given collection, or
+-- unfiles it when that is NULL. With keep_collection it stays where it is.
+INSERT INTO bookmarks (user_id, chirp_id, collection_id, created_at)
+VALUES (
+    sqlc.arg(user_id),
+    sqlc.arg(chirp_id),
+    sqlc.narg(collection_id),
+    NOW()
+)
+ON CONFLICT (user_id, chirp_id) DO UPDATE SET collection_id = CASE
+    WHEN sqlc.arg(keep_collection)::boolean THEN bookmarks.collection_id
+    ELSE EXCLUDED.collection_id
+END
+RETURNING *;
+
+-- name: DeleteBookmark :exec
+DELETE FROM bookmarks WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetBookmarkedChirpIds :many
+SELECT chirp_id FROM bookmarks WHERE user_id = sqlc.arg(user_id) AND chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
+
+-- name: GetBookmarksByUser :many
+SELECT bookmarks.* FROM bookmarks
+JOIN chirps ON chirps.id = bookmarks.chirp_id
+WHERE bookmarks.user_id = sqlc.arg(user_id)
+AND (sqlc.narg(collection_id)::uuid IS NULL OR bookmarks.collection_id = sqlc.narg(collection_id)::uuid)
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = chirps.user_id AND blocked_id = sqlc.arg(user_id))
+       OR (blocker_id = sqlc.arg(user_id) AND blocked_id = chirps.user_id)
+)
+ORDER BY bookmarks.created_at DESC, bookmarks.chirp_id DESC
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: CreateBookmarkCollection :one
+INSERT INTO bookmark_collections (id, created_at, updated_at, user_id, name)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: GetBookmarkCollection :one
+SELECT * FROM bookmark_collections WHERE id = $1;
+
+-- name: GetBookmarkCollectionsByUser :many
+SELECT * FROM bookmark_collections WHERE user_id = $1 ORDER BY name ASC;
+
+-- name: DeleteBookmarkCollection :exec
+DELETE FROM bookmark_collections WHERE id = $1 AND user_id = $2;
@@ -0,0 +1,34 @@
+-- name: CreateChirpAttachment :one
+INSERT INTO chirp_attachments (id, created_at, chirp_id, content_type, size_bytes, width, height, storage_key, thumbnail_key)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING *;
+
+-- name: GetAttachmentsByChirpId :many
+SELECT * FROM chirp_attachments WHERE chirp_id = $1 ORDER BY created_at ASC;
+
+-- name: GetAttachmentsByChirpIds :many
+SELECT * FROM chirp_attachments WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]) ORDER BY created_at ASC;
+
+-- name: CountAttachmentsByChirpId :one
+SELECT COUNT(*) FROM chirp_attachments WHERE chirp_id = $1;
+
+-- name: LockChirpForAttachment :one
+-- LockChirpForAttachment locks the chirp row until the transaction ends, so
+-- concurrent uploads to one chirp take turns counting its attachments. It
+-- returns the chirp's author.
+SELECT user_id FROM chirps WHERE id = $1 FOR UPDATE;
+
+-- name: GetAttachmentsByUserId :many
+SELECT chirp_attachments.* FROM chirp_attachments
+JOIN chirps ON chirps.id = chirp_attachments.chirp_id
+WHERE chirps.user_id = $1;
@@ -1,22 +1,118 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, status, publish_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
 RETURNING *;
 
 -- name: GetAllChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+SELECT * FROM chirps
+WHERE status = 'published'
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = chirps.user_id AND blocked_id = sqlc.narg(viewer_id)::uuid)
+       OR (blocker_id = sqlc.narg(viewer_id)::uuid AND blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE muter_id = sqlc.narg(viewer_id)::uuid AND muted_id = chirps.user_id
+)
+ORDER BY created_at ASC;
 
 -- name: GetAllChirpsByUserId :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+SELECT * FROM chirps
+WHERE user_id = sqlc.arg(user_id)
+AND status = 'published'
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = chirps.user_id AND blocked_id = sqlc.narg(viewer_id)::uuid)
+       OR (blocker_id = sqlc.narg(viewer_id)::uuid AND blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE muter_id = sqlc.narg(viewer_id)::uuid AND muted_id = chirps.user_id
+)
+ORDER BY created_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps WHERE id = $1;
 
 -- name: DeleteChirp :exec
-DELETE FROM chirps WHERE id = $1;
\ No newline at end of file
+DELETE FROM chirps WHERE id = $1;
+
+-- name: GetChirpsByUserIdPage :many
+SELECT * FROM chirps
+WHERE user_id = sqlc.arg(user_id)
+  AND (created_at, id) > (sqlc.arg(after_created_at)::timestamp, sqlc.arg(after_id)::uuid)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(page_size);
+
+-- name: CreateRepost :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, kind, original_chirp_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: AdjustRepostCounts :exec
+UPDATE chirps
+SET rechirp_count = rechirp_count + sqlc.arg(rechirp_delta)::int,
+    quote_count = quote_count + sqlc.arg(quote_delta)::int
+WHERE id = sqlc.arg(id);
+
+-- name: GetChirpsByIds :many
+SELECT * FROM chirps WHERE id = ANY(sqlc.arg(ids)::uuid[]) AND status = 'published';
+
+-- name: ReleaseRepostCountsByUser :exec
+UPDATE chirps
+SET rechirp_count = chirps.rechirp_count - reposts.rechirps,
+    quote_count = chirps.quote_count - reposts.quotes
+FROM (
+    SELECT original_chirp_id,
+        COUNT(*) FILTER (WHERE kind = 'rechirp') AS rechirps,
+        COUNT(*) FILTER (WHERE kind = 'quote') AS quotes
+    FROM chirps
+    WHERE user_id = $1 AND original_chirp_id IS NOT NULL
+    GROUP BY original_chirp_id
+) AS reposts
+WHERE chirps.id = reposts.original_chirp_id;
+
+
+-- name: GetUnpublishedChirpsByUserId :many
+SELECT * FROM chirps WHERE user_id = $1 AND status <> 'published' ORDER BY publish_at ASC NULLS LAST, created_at ASC;
+
+-- name: PublishChirp :one
+UPDATE chirps SET status = 'published', publish_at = NULL, created_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status <> 'published'
+RETURNING *;
+
+-- name: PublishDueChirps :many
+-- PublishDueChirps claims due chirps with SKIP LOCKED so that several server
+-- instances can run the scheduler without publishing the same chirp twice.
+-- The chirp takes its scheduled time as created_at so it sorts where it was
+-- meant to appear, and like PublishChirp clears publish_at.
+UPDATE chirps SET status = 'published', created_at = publish_at, publish_at = NULL, updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE status = 'scheduled' AND publish_at <= NOW()
+    ORDER BY publish_at ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+-- name: NotifyChirpStream :exec
+-- NotifyChirpStream tells every server instance listening on chirp_stream
+-- about a new chirp.
+SELECT pg_notify('chirp_stream', sqlc.arg(payload)::text);
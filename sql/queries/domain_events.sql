@@ -0,0 +1,53 @@
+-- name: CreateDomainEvent :exec
+INSERT INTO domain_events (id, created_at, event_type, user_id, payload, next_attempt_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW()
+);
+
+-- name: ClaimDueDomainEvents :many
+-- ClaimDueDomainEvents leases due events by pushing next_attempt_at out, so
+-- other instances skip them while this one dispatches. If the server dies
+-- the lease runs out and the events are dispatched again.
+UPDATE domain_events SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg(lease_seconds)::int)
+WHERE id IN (
+    SELECT id FROM domain_events
+    WHERE dispatched_at IS NULL AND abandoned_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY created_at ASC
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: IsDomainEventDelivered :one
+SELECT EXISTS (
+    SELECT 1 FROM domain_event_deliveries
+    WHERE event_id = $1 AND subscriber = $2
+);
+
+-- name: CreateDomainEventDelivery :exec
+INSERT INTO domain_event_deliveries (event_id, subscriber, delivered_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (event_id, subscriber) DO NOTHING;
+
+-- name: MarkDomainEventDispatched :exec
+UPDATE domain_events SET dispatched_at = NOW(), last_error = NULL WHERE id = $1;
+
+-- name: RetryDomainEvent :exec
+UPDATE domain_events
+SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+WHERE id = $1;
+
+-- name: AbandonDomainEvent :exec
+-- AbandonDomainEvent gives up on an event that kept failing. It's no longer
+-- claimed and, not being dispatched, isn't pruned either.
+UPDATE domain_events
+SET attempts = attempts + 1, abandoned_at = NOW(), last_error = $2
+WHERE id = $1;
+
+-- name: DeleteDispatchedDomainEvents :exec
+DELETE FROM domain_events WHERE dispatched_at < $1;
@@ -0,0 +1,26 @@
+-- name: UpsertLinkPreview :exec
+INSERT INTO link_previews (url, fetched_at, ok, title, description, image_url, site_name, error)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+ON CONFLICT (url) DO UPDATE SET
+    fetched_at = EXCLUDED.fetched_at,
+    ok = EXCLUDED.ok,
+    title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    image_url = EXCLUDED.image_url,
+    site_name = EXCLUDED.site_name,
+    error = EXCLUDED.error;
+
+-- name: GetLinkPreview :one
+SELECT * FROM link_previews WHERE url = $1;
+
+-- name: GetLinkPreviewsByUrls :many
+SELECT * FROM link_previews WHERE url = ANY(sqlc.arg(urls)::text[]) AND ok;
@@ -0,0 +1,53 @@
+-- name: UpsertNotification :one
+-- UpsertNotification adds the actor to the recipient's unread notification
+-- for this chirp and type, or starts a new one. Adding the same actor twice
+-- is a no-op returning no rows, which keeps repeated event deliveries
+-- harmless.
+INSERT INTO notifications (id, created_at, updated_at, recipient_id, type, chirp_id, actor_ids)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    sqlc.arg(recipient_id),
+    sqlc.arg(type),
+    sqlc.arg(chirp_id),
+    ARRAY[sqlc.arg(actor_id)::uuid]
+)
+ON CONFLICT (recipient_id, type, chirp_id) WHERE read_at IS NULL DO UPDATE SET
+    actor_ids = array_append(notifications.actor_ids, sqlc.arg(actor_id)::uuid),
+    updated_at = NOW()
+WHERE NOT sqlc.arg(actor_id)::uuid = ANY(notifications.actor_ids)
+RETURNING *;
+
+-- name: GetNotificationsByUser :many
+SELECT * FROM notifications
+WHERE recipient_id = sqlc.arg(recipient_id)
+AND (NOT sqlc.arg(unread_only)::bool OR read_at IS NULL)
+ORDER BY updated_at DESC, id DESC
+LIMIT sqlc.arg(page_limit)
+OFFSET sqlc.arg(page_offset);
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications WHERE recipient_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE recipient_id = sqlc.arg(recipient_id) AND read_at IS NULL
+AND id = ANY(sqlc.arg(ids)::uuid[]);
+
+-- name: MarkAllNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE recipient_id = $1 AND read_at IS NULL;
+
+-- name: GetNotificationPreferences :many
+SELECT * FROM notification_preferences WHERE user_id = $1;
+
+-- name: UpsertNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled;
+
+-- name: NotifyNotificationStream :exec
+-- NotifyNotificationStream tells every server instance listening on
+-- notification_stream about a new or updated notification.
+SELECT pg_notify('notification_stream', sqlc.arg(payload)::text);
@@ -0,0 +1,100 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (id, created_at, updated_at, user_id, url, secret, event_types)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions WHERE id = $1;
+
+-- name: GetWebhookSubscriptionsByUser :many
+SELECT * FROM webhook_subscriptions
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetAdminWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions
+WHERE user_id IS NULL
+ORDER BY created_at ASC;
+
+-- name: CountWebhookSubscriptionsByUser :one
+SELECT COUNT(*) FROM webhook_subscriptions WHERE user_id = $1;
+
+-- name: DeleteWebhookSubscription :exec
+DELETE FROM webhook_subscriptions WHERE id = $1;
+
+-- name: EnqueueWebhookDeliveries :exec
+-- EnqueueWebhookDeliveries fans an event out to every subscription that
+-- wants it: the admin ones, and the ones of the user the event is about.
+INSERT INTO webhook_deliveries (id, created_at, subscription_id, event_type, payload, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), webhook_subscriptions.id, sqlc.arg(event_type), sqlc.arg(payload), NOW()
+FROM webhook_subscriptions
+WHERE sqlc.arg(event_type)::text = ANY(webhook_subscriptions.event_types)
+AND (webhook_subscriptions.user_id IS NULL OR webhook_subscriptions.user_id = sqlc.arg(user_id));
+
+-- name: ClaimDueWebhookDeliveries :many
+-- ClaimDueWebhookDeliveries leases due deliveries by pushing next_attempt_at
+-- out, so other instances skip them while this one is sending. If the
+-- server dies mid-send the lease runs out and the delivery is retried.
+UPDATE webhook_deliveries SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg(lease_seconds)::int)
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at ASC
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = attempts + 1, delivered_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookDeliveryFailed :exec
+-- MarkWebhookDeliveryFailed schedules the next try, or with a status of
+-- 'dead' gives up on the delivery.
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, next_attempt_at = $3
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :one
+-- RetryWebhookDelivery starts a dead delivery over with the full backoff
+-- schedule ahead of it. Earlier attempts stay in webhook_delivery_attempts.
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+WHERE id = $1 AND status = 'dead'
+RETURNING *;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries WHERE id = $1;
+
+-- name: GetWebhookDeliveriesBySubscription :many
+SELECT * FROM webhook_deliveries
+WHERE subscription_id = sqlc.arg(subscription_id)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit)
+OFFSET sqlc.arg(page_offset);
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, delivery_id, attempted_at, duration_ms, status_code, error)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: GetWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE delivery_id = ANY(sqlc.arg(delivery_ids)::uuid[])
+ORDER BY attempted_at ASC;
@@ -0,0 +1,27 @@
+-- name: AddPageHits :exec
+-- Adds a batch of counts at once; the three arrays are read in parallel.
+INSERT INTO page_hits (path, day, hits)
+SELECT h.path, h.day::date, h.hits
+FROM unnest(sqlc.arg(paths)::text[], sqlc.arg(days)::text[], sqlc.arg(hits)::bigint[]) AS h(path, day, hits)
+ON CONFLICT (path, day) DO UPDATE SET hits = page_hits.hits + EXCLUDED.hits;
+
+-- name: GetTotalPageHits :one
+SELECT COALESCE(SUM(hits), 0)::bigint AS hits FROM page_hits;
+
+-- name: ListPageHitsByPath :many
+SELECT path, SUM(hits)::bigint AS hits
+FROM page_hits
+WHERE day >= sqlc.arg(since)::date
+GROUP BY path
+ORDER BY hits DESC, path
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListPageHitsByDay :many
+SELECT day, SUM(hits)::bigint AS hits
+FROM page_hits
+WHERE day >= sqlc.arg(since)::date
+GROUP BY day
+ORDER BY day DESC;
+
+-- name: ResetPageHits :exec
+DELETE FROM page_hits;
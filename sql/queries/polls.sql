@@ -0,0 +1,45 @@
+-- name: CreatePoll :one
+INSERT INTO polls (id, created_at, chirp_id, closes_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: CreatePollOption :one
+INSERT INTO poll_options (id, poll_id, position, label)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetPollByChirpId :one
+SELECT * FROM polls WHERE chirp_id = $1;
+
+-- name: GetPollsByChirpIds :many
+SELECT * FROM polls WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
+
+-- name: GetPollOptionsWithVotes :many
+SELECT poll_options.id, poll_options.poll_id, poll_options.position, poll_options.label, COUNT(poll_votes.user_id) AS votes
+FROM poll_options
+LEFT JOIN poll_votes ON poll_votes.option_id = poll_options.id
+WHERE poll_options.poll_id = ANY(sqlc.arg(poll_ids)::uuid[])
+GROUP BY poll_options.id
+ORDER BY poll_options.poll_id, poll_options.position ASC;
+
+-- name: GetPollVotesByUser :many
+SELECT * FROM poll_votes WHERE user_id = sqlc.arg(user_id) AND poll_id = ANY(sqlc.arg(poll_ids)::uuid[]);
+
+-- name: CreatePollVote :one
+-- CreatePollVote only inserts while the poll is open, a closed poll gives
+-- sql.ErrNoRows.
+INSERT INTO poll_votes (poll_id, user_id, option_id, created_at)
+SELECT polls.id, sqlc.arg(user_id), sqlc.arg(option_id), NOW()
+FROM polls
+WHERE polls.id = sqlc.arg(poll_id) AND polls.closes_at > NOW()
+RETURNING *;
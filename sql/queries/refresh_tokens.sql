@@ -15,3 +15,9 @@ SELECT * FROM refresh_tokens WHERE token = $1;
 -- name: RevokeToken :exec
 UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE token = $1;
 
+
+-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: GetRefreshTokensByUserId :many
+SELECT * FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at ASC;
@@ -0,0 +1,70 @@
+-- name: GetSubscriptionByUserId :one
+SELECT * FROM subscriptions WHERE user_id = $1;
+
+-- name: UpsertSubscription :one
+-- UpsertSubscription starts a new period for the user, reactivating the
+-- subscription if it was canceled or had expired.
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, current_period_start, current_period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'active',
+    $2,
+    $3
+)
+ON CONFLICT (user_id) DO UPDATE SET
+    status = 'active',
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    canceled_at = NULL,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: CancelSubscription :one
+-- CancelSubscription stops renewals. The user keeps Chirpy Red until the
+-- period they paid for runs out.
+UPDATE subscriptions SET status = 'canceled', canceled_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND status = 'active'
+RETURNING *;
+
+-- name: EndSubscription :one
+-- EndSubscription expires the subscription right away, cutting the current
+-- period short.
+UPDATE subscriptions
+SET status = 'expired',
+    current_period_end = LEAST(current_period_end, NOW()),
+    updated_at = NOW()
+WHERE user_id = $1 AND status <> 'expired'
+RETURNING *;
+
+-- name: ExpireDueSubscriptions :many
+-- ExpireDueSubscriptions claims lapsed subscriptions with SKIP LOCKED so that
+-- several server instances can run the expiry job side by side.
+UPDATE subscriptions SET status = 'expired', updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM subscriptions
+    WHERE status <> 'expired' AND current_period_end <= NOW()
+    ORDER BY current_period_end ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CreateSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, subscription_id, event, status, period_start, period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: GetSubscriptionEvents :many
+SELECT * FROM subscription_events
+WHERE subscription_id = $1
+ORDER BY created_at DESC;
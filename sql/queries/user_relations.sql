@@ -0,0 +1,43 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :exec
+DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: IsBlockedEitherWay :one
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = sqlc.arg(user_a) AND blocked_id = sqlc.arg(user_b))
+       OR (blocker_id = sqlc.arg(user_b) AND blocked_id = sqlc.arg(user_a))
+);
+
+-- name: MuteUser :exec
+INSERT INTO user_mutes (muter_id, muted_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnmuteUser :exec
+DELETE FROM user_mutes WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: GetBlockRelatedUserIds :many
+SELECT blocked_id AS user_id FROM user_blocks WHERE blocker_id = $1
+UNION
+SELECT blocker_id AS user_id FROM user_blocks WHERE blocked_id = $1;
+
+-- name: IsMuted :one
+SELECT EXISTS (
+    SELECT 1 FROM user_mutes WHERE muter_id = $1 AND muted_id = $2
+);
+
+-- name: GetMutedUserIds :many
+SELECT muted_id FROM user_mutes WHERE muter_id = $1;
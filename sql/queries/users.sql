@@ -14,15 +14,33 @@ SELECT * FROM users WHERE id = $1;
 
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = $1;
+SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL;
 
 -- name: DeleteAllUsers :exec
 DELETE FROM users;
 
 -- name: UpdateUserEmailAndPassword :one
-UPDATE users SET email = $1, hashed_password = $2, updated_at = NOW() WHERE id = $3
+UPDATE users SET email = $1, hashed_password = $2, updated_at = NOW() WHERE id = $3 AND deleted_at IS NULL
 RETURNING *;
 
 -- name: UpdateUserChirpyRed :one
 UPDATE users SET is_chirpy_red = $1, updated_at = NOW() WHERE id = $2
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: UpdateUserAvatar :one
+UPDATE users SET avatar_key = $1, updated_at = NOW() WHERE id = $2
+RETURNING *;
+
+-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1;
+
+-- name: AnonymizeUser :one
+UPDATE users
+SET email = 'deleted-' || id || '@deleted.invalid',
+    hashed_password = 'unset',
+    is_chirpy_red = false,
+    avatar_key = NULL,
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,42 @@
+-- name: CreateWebhookEvent :one
+-- CreateWebhookEvent returns no rows when the provider already sent an
+-- event with this ID.
+INSERT INTO webhook_events (id, received_at, provider, event_id, event_type, payload)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events WHERE id = $1;
+
+-- name: GetWebhookEventByEventId :one
+SELECT * FROM webhook_events WHERE provider = $1 AND event_id = $2;
+
+-- name: LockWebhookEvent :one
+-- LockWebhookEvent serializes concurrent deliveries of the same event so
+-- only one of them gets to apply it.
+SELECT * FROM webhook_events WHERE id = $1 FOR UPDATE;
+
+-- name: MarkWebhookEventDone :exec
+UPDATE webhook_events
+SET status = $2, attempts = attempts + 1, last_error = NULL, processed_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events
+SET status = 'failed', attempts = attempts + 1, last_error = $2
+WHERE id = $1;
+
+-- name: GetWebhookEventsByStatus :many
+SELECT * FROM webhook_events
+WHERE status = sqlc.arg(status)
+ORDER BY received_at DESC, id DESC
+LIMIT sqlc.arg(page_limit)
+OFFSET sqlc.arg(page_offset);
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE user_blocks(
+    blocker_id UUID NOT NULL,
+    blocked_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id),
+    CONSTRAINT fk_blocker_id
+    FOREIGN KEY (blocker_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_blocked_id
+    FOREIGN KEY (blocked_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE user_mutes(
+    muter_id UUID NOT NULL,
+    muted_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (muter_id, muted_id),
+    CONSTRAINT fk_muter_id
+    FOREIGN KEY (muter_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_muted_id
+    FOREIGN KEY (muted_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE user_mutes;
+DROP TABLE user_blocks;
@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN kind TEXT NOT NULL DEFAULT 'chirp',
+ADD COLUMN original_chirp_id UUID,
+ADD COLUMN rechirp_count INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN quote_count INTEGER NOT NULL DEFAULT 0,
+ADD CONSTRAINT chirps_kind_check CHECK (kind IN ('chirp', 'rechirp', 'quote')),
+ADD CONSTRAINT fk_original_chirp_id
+    FOREIGN KEY (original_chirp_id)
+    REFERENCES chirps (id)
+    ON DELETE SET NULL;
+
+-- a plain rechirp can only happen once per user, quotes are unlimited
+CREATE UNIQUE INDEX chirps_one_rechirp_per_user_idx ON chirps (user_id, original_chirp_id) WHERE kind = 'rechirp';
+
+-- +goose Down
+DROP INDEX chirps_one_rechirp_per_user_idx;
+
+ALTER TABLE chirps
+DROP CONSTRAINT fk_original_chirp_id,
+DROP CONSTRAINT chirps_kind_check,
+DROP COLUMN quote_count,
+DROP COLUMN rechirp_count,
+DROP COLUMN original_chirp_id,
+DROP COLUMN kind;
@@ -0,0 +1,49 @@
+-- +goose Up
+CREATE TABLE polls(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL UNIQUE,
+    closes_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps (id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE poll_options(
+    id UUID PRIMARY KEY,
+    poll_id UUID NOT NULL,
+    position INTEGER NOT NULL,
+    label TEXT NOT NULL,
+    UNIQUE (poll_id, position),
+    -- lets poll_votes check that the option belongs to the poll voted on
+    UNIQUE (id, poll_id),
+    CONSTRAINT fk_poll_id
+    FOREIGN KEY (poll_id)
+    REFERENCES polls (id)
+    ON DELETE CASCADE
+);
+
+-- the primary key is what limits a user to a single vote per poll
+CREATE TABLE poll_votes(
+    poll_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    option_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (poll_id, user_id),
+    CONSTRAINT fk_option_id
+    FOREIGN KEY (option_id, poll_id)
+    REFERENCES poll_options (id, poll_id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX poll_votes_option_id_idx ON poll_votes (option_id);
+
+-- +goose Down
+DROP TABLE poll_votes;
+DROP TABLE poll_options;
+DROP TABLE polls;
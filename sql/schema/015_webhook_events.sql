@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE webhook_events(
+    id UUID PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    processed_at TIMESTAMP,
+    UNIQUE (provider, event_id),
+    CONSTRAINT webhook_events_status_check CHECK (status IN ('pending', 'processed', 'ignored', 'failed'))
+);
+
+CREATE INDEX webhook_events_status_idx ON webhook_events (status, received_at) WHERE status IN ('pending', 'failed');
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- the outbox: written in the same transaction as the change an event
+-- describes and drained by the in-process event bus
+CREATE TABLE domain_events(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    user_id UUID,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT,
+    dispatched_at TIMESTAMP
+);
+
+CREATE INDEX domain_events_due_idx ON domain_events (next_attempt_at) WHERE dispatched_at IS NULL;
+CREATE INDEX domain_events_dispatched_at_idx ON domain_events (dispatched_at) WHERE dispatched_at IS NOT NULL;
+
+-- which subscribers already got an event, so a retry only reaches the ones
+-- that failed
+CREATE TABLE domain_event_deliveries(
+    event_id UUID NOT NULL,
+    subscriber TEXT NOT NULL,
+    delivered_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (event_id, subscriber),
+    CONSTRAINT fk_event_id
+    FOREIGN KEY (event_id)
+    REFERENCES domain_events (id)
+    ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE domain_event_deliveries;
+DROP TABLE domain_events;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- one row stands for a burst of the same thing happening to the same chirp:
+-- further actors are folded into the unread row until it is read
+CREATE TABLE notifications(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    recipient_id UUID NOT NULL,
+    type TEXT NOT NULL,
+    chirp_id UUID NOT NULL,
+    actor_ids UUID[] NOT NULL,
+    read_at TIMESTAMP,
+    CONSTRAINT fk_recipient_id
+    FOREIGN KEY (recipient_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX notifications_unread_group_idx ON notifications (recipient_id, type, chirp_id) WHERE read_at IS NULL;
+CREATE INDEX notifications_recipient_id_idx ON notifications (recipient_id, updated_at);
+
+-- types without a row are enabled
+CREATE TABLE notification_preferences(
+    user_id UUID NOT NULL,
+    type TEXT NOT NULL,
+    enabled BOOL NOT NULL,
+    PRIMARY KEY (user_id, type),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
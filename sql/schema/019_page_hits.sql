@@ -0,0 +1,13 @@
+-- +goose Up
+-- one row per path and day; instances add their counts in batches
+CREATE TABLE page_hits(
+    path TEXT NOT NULL,
+    day DATE NOT NULL,
+    hits BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (path, day)
+);
+
+CREATE INDEX page_hits_day_idx ON page_hits (day);
+
+-- +goose Down
+DROP TABLE page_hits;
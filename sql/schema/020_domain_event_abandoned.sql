@@ -0,0 +1,15 @@
+-- +goose Up
+-- events that failed too often are set aside here instead of being retried
+-- forever; they stay until someone has looked at last_error
+ALTER TABLE domain_events
+ADD COLUMN abandoned_at TIMESTAMP;
+
+DROP INDEX domain_events_due_idx;
+CREATE INDEX domain_events_due_idx ON domain_events (next_attempt_at) WHERE dispatched_at IS NULL AND abandoned_at IS NULL;
+
+-- +goose Down
+DROP INDEX domain_events_due_idx;
+CREATE INDEX domain_events_due_idx ON domain_events (next_attempt_at) WHERE dispatched_at IS NULL;
+
+ALTER TABLE domain_events
+DROP COLUMN abandoned_at;
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	subscriptionStatusActive = "active"
+	// canceled subscriptions keep Chirpy Red until the paid period ends
+	subscriptionStatusCanceled = "canceled"
+	subscriptionStatusExpired  = "expired"
+
+	polkaEventUpgraded   = "user.upgraded"
+	polkaEventRenewed    = "user.renewed"
+	polkaEventCanceled   = "user.canceled"
+	polkaEventDowngraded = "user.downgraded"
+	polkaEventRefunded   = "user.refunded"
+
+	// the expiry job uses this as its event name in the history
+	subscriptionEventExpired = "expired"
+
+	redSubscriptionPeriod      = 30 * 24 * time.Hour
+	expiryBatchSize            = 100
+	subscriptionExpiryInterval = time.Minute
+)
+
+var handledPolkaEvents = map[string]bool{
+	polkaEventUpgraded:   true,
+	polkaEventRenewed:    true,
+	polkaEventCanceled:   true,
+	polkaEventDowngraded: true,
+	polkaEventRefunded:   true,
+}
+
+type SubscriptionEventDTO struct {
+	Event       string    `json:"event"`
+	Status      string    `json:"status"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SubscriptionDTO struct {
+	Status             string                 `json:"status"`
+	IsChirpyRed        bool                   `json:"is_chirpy_red"`
+	CurrentPeriodStart time.Time              `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time              `json:"current_period_end"`
+	CanceledAt         *time.Time             `json:"canceled_at"`
+	History            []SubscriptionEventDTO `json:"history"`
+}
+
+// applySubscriptionEvent moves the user's subscription along after a Polka
+// event and keeps users.is_chirpy_red in step with it. It has to run inside
+// a transaction so the subscription, the flag and the history agree.
+func applySubscriptionEvent(ctx context.Context, qtx *database.Queries, userID uuid.UUID, event string) error {
+	var subscription database.Subscription
+	var err error
+
+	switch event {
+	case polkaEventUpgraded, polkaEventRenewed:
+		now := time.Now().UTC()
+		params := database.UpsertSubscriptionParams{
+			UserID:             userID,
+			CurrentPeriodStart: now,
+			CurrentPeriodEnd:   now.Add(redSubscriptionPeriod),
+		}
+
+		current, err := qtx.GetSubscriptionByUserId(ctx, userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		running := err == nil && current.Status != subscriptionStatusExpired && current.CurrentPeriodEnd.After(now)
+
+		// an upgrade while the subscription is active is a duplicate
+		// delivery, not a payment; only a renewal pays for another period
+		if event == polkaEventUpgraded && running && current.Status == subscriptionStatusActive {
+			return nil
+		}
+
+		// paying before the current period runs out extends it rather than
+		// throwing away the days that are left
+		if running {
+			params.CurrentPeriodStart = current.CurrentPeriodStart
+			params.CurrentPeriodEnd = current.CurrentPeriodEnd.Add(redSubscriptionPeriod)
+		}
+
+		subscription, err = qtx.UpsertSubscription(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		_, err = qtx.UpdateUserChirpyRed(ctx, database.UpdateUserChirpyRedParams{
+			IsChirpyRed: true,
+			ID:          userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if event == polkaEventUpgraded {
+			err = publishEvent(ctx, qtx, eventUserUpgraded, userID, userEventPayload{UserID: userID, IsChirpyRed: true})
+			if err != nil {
+				return err
+			}
+		}
+	case polkaEventCanceled:
+		subscription, err = qtx.CancelSubscription(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// nothing active to cancel
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	case polkaEventDowngraded, polkaEventRefunded:
+		_, err = qtx.UpdateUserChirpyRed(ctx, database.UpdateUserChirpyRedParams{
+			IsChirpyRed: false,
+			ID:          userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = publishEvent(ctx, qtx, eventUserDowngraded, userID, userEventPayload{UserID: userID})
+		if err != nil {
+			return err
+		}
+
+		subscription, err = qtx.EndSubscription(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	return recordSubscriptionEvent(ctx, qtx, subscription, event)
+}
+
+func recordSubscriptionEvent(ctx context.Context, qtx *database.Queries, subscription database.Subscription, event string) error {
+	return qtx.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+		SubscriptionID: subscription.ID,
+		Event:          event,
+		Status:         subscription.Status,
+		PeriodStart:    subscription.CurrentPeriodStart,
+		PeriodEnd:      subscription.CurrentPeriodEnd,
+	})
+}
+
+// runSubscriptionExpiry takes Chirpy Red away from users whose paid period
+// ran out without a renewal.
+func (cfg *apiConfig) runSubscriptionExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.expireSubscriptions(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) {
+	for {
+		expired, err := cfg.expireSubscriptionsBatch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error expiring subscriptions", "error", err)
+			}
+			return
+		}
+
+		if expired < expiryBatchSize {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) expireSubscriptionsBatch(ctx context.Context) (int, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	subscriptions, err := qtx.ExpireDueSubscriptions(ctx, expiryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, subscription := range subscriptions {
+		_, err = qtx.UpdateUserChirpyRed(ctx, database.UpdateUserChirpyRedParams{
+			IsChirpyRed: false,
+			ID:          subscription.UserID,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		err = publishEvent(ctx, qtx, eventUserDowngraded, subscription.UserID, userEventPayload{UserID: subscription.UserID})
+		if err != nil {
+			return 0, err
+		}
+
+		err = recordSubscriptionEvent(ctx, qtx, subscription, subscriptionEventExpired)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(subscriptions), tx.Commit()
+}
+
+func (cfg *apiConfig) getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	subscription, err := cfg.db.GetSubscriptionByUserId(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "Subscription not found")
+		return
+	}
+
+	events, err := cfg.db.GetSubscriptionEvents(r.Context(), subscription.ID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving subscription")
+		return
+	}
+
+	respBody := SubscriptionDTO{
+		Status:             subscription.Status,
+		IsChirpyRed:        user.IsChirpyRed,
+		CurrentPeriodStart: subscription.CurrentPeriodStart,
+		CurrentPeriodEnd:   subscription.CurrentPeriodEnd,
+		History:            make([]SubscriptionEventDTO, 0, len(events)),
+	}
+	if subscription.CanceledAt.Valid {
+		respBody.CanceledAt = &subscription.CanceledAt.Time
+	}
+	for _, event := range events {
+		respBody.History = append(respBody.History, SubscriptionEventDTO{
+			Event:       event.Event,
+			Status:      event.Status,
+			PeriodStart: event.PeriodStart,
+			PeriodEnd:   event.PeriodEnd,
+			CreatedAt:   event.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+var subscriptionColumns = []string{"id", "created_at", "updated_at", "user_id", "status", "current_period_start", "current_period_end", "canceled_at"}
+
+func subscriptionRow(userID uuid.UUID, status string, start, end time.Time) []driver.Value {
+	return []driver.Value{uuid.NewString(), start, start, userID.String(), status, start, end, nil}
+}
+
+// subscriptionFake answers every query applySubscriptionEvent may run, with
+// current as the user's subscription before the event.
+func subscriptionFake(t *testing.T, userID uuid.UUID, current []driver.Value) (*fakeDB, *database.Queries) {
+	t.Helper()
+	now := time.Now()
+	fake, conn := newFakeDB(t)
+	if current != nil {
+		fake.returns("GetSubscriptionByUserId", subscriptionColumns, current)
+		fake.returns("CancelSubscription", subscriptionColumns, current)
+		fake.returns("EndSubscription", subscriptionColumns, current)
+	} else {
+		fake.returns("GetSubscriptionByUserId", subscriptionColumns)
+		fake.returns("CancelSubscription", subscriptionColumns)
+		fake.returns("EndSubscription", subscriptionColumns)
+	}
+	fake.returns("UpsertSubscription", subscriptionColumns, subscriptionRow(userID, subscriptionStatusActive, now, now.Add(redSubscriptionPeriod)))
+	fake.returns("UpdateUserChirpyRed", userColumns, []driver.Value{userID.String(), now, now, "a@example.com", "hash", false, nil, nil})
+	fake.returns("CreateDomainEvent", nil)
+	fake.returns("CreateSubscriptionEvent", nil)
+	return fake, database.New(conn)
+}
+
+func TestApplySubscriptionEvent(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now().UTC()
+	tenDaysLeft := now.Add(10 * 24 * time.Hour)
+	startedAt := now.Add(-20 * 24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		event   string
+		current []driver.Value
+		// wantEnd is the period end UpsertSubscription should be given, zero
+		// when it shouldn't run
+		wantEnd time.Time
+		// wantRed is what is_chirpy_red should be set to, nil when it
+		// shouldn't be touched
+		wantRed     any
+		wantEvent   bool
+		wantHistory bool
+	}{
+		{"upgrade, first time", polkaEventUpgraded, nil, now.Add(redSubscriptionPeriod), true, true, true},
+		{"upgrade, already active", polkaEventUpgraded, subscriptionRow(userID, subscriptionStatusActive, startedAt, tenDaysLeft), time.Time{}, nil, false, false},
+		{"upgrade, canceled but paid up", polkaEventUpgraded, subscriptionRow(userID, subscriptionStatusCanceled, startedAt, tenDaysLeft), tenDaysLeft.Add(redSubscriptionPeriod), true, true, true},
+		{"upgrade, expired", polkaEventUpgraded, subscriptionRow(userID, subscriptionStatusExpired, startedAt, now.Add(-time.Hour)), now.Add(redSubscriptionPeriod), true, true, true},
+		{"renew, active", polkaEventRenewed, subscriptionRow(userID, subscriptionStatusActive, startedAt, tenDaysLeft), tenDaysLeft.Add(redSubscriptionPeriod), true, false, true},
+		{"renew, lapsed before expiry ran", polkaEventRenewed, subscriptionRow(userID, subscriptionStatusActive, startedAt, now.Add(-time.Hour)), now.Add(redSubscriptionPeriod), true, false, true},
+		{"cancel, active", polkaEventCanceled, subscriptionRow(userID, subscriptionStatusActive, startedAt, tenDaysLeft), time.Time{}, nil, false, true},
+		{"cancel, nothing active", polkaEventCanceled, nil, time.Time{}, nil, false, false},
+		{"refund", polkaEventRefunded, subscriptionRow(userID, subscriptionStatusActive, startedAt, tenDaysLeft), time.Time{}, false, true, true},
+		{"downgrade, no subscription", polkaEventDowngraded, nil, time.Time{}, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, qtx := subscriptionFake(t, userID, tt.current)
+
+			err := applySubscriptionEvent(context.Background(), qtx, userID, tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantEnd.IsZero() {
+				if fake.didRun("UpsertSubscription") {
+					t.Error("subscription upserted")
+				}
+			} else {
+				// user_id, current_period_start, current_period_end
+				args := fake.argsOf("UpsertSubscription")
+				if len(args) != 3 {
+					t.Fatalf("UpsertSubscription args = %v", args)
+				}
+				end, _ := args[2].(time.Time)
+				if end.Sub(tt.wantEnd).Abs() > time.Minute {
+					t.Errorf("period ends %v, want %v", end, tt.wantEnd)
+				}
+			}
+
+			if tt.wantRed == nil {
+				if fake.didRun("UpdateUserChirpyRed") {
+					t.Error("is_chirpy_red changed")
+				}
+			} else {
+				// is_chirpy_red, id
+				args := fake.argsOf("UpdateUserChirpyRed")
+				if len(args) != 2 || args[0] != tt.wantRed {
+					t.Errorf("UpdateUserChirpyRed args = %v, want is_chirpy_red %v", args, tt.wantRed)
+				}
+			}
+
+			if fake.didRun("CreateDomainEvent") != tt.wantEvent {
+				t.Errorf("domain event published = %v", fake.didRun("CreateDomainEvent"))
+			}
+			if fake.didRun("CreateSubscriptionEvent") != tt.wantHistory {
+				t.Errorf("history recorded = %v", fake.didRun("CreateSubscriptionEvent"))
+			}
+		})
+	}
+}
+
+func TestExpireSubscriptions(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now().UTC()
+	fake, conn := newFakeDB(t)
+	fake.returns("ExpireDueSubscriptions", subscriptionColumns, subscriptionRow(userID, subscriptionStatusExpired, now.Add(-redSubscriptionPeriod), now.Add(-time.Minute)))
+	fake.returns("UpdateUserChirpyRed", userColumns, []driver.Value{userID.String(), now, now, "a@example.com", "hash", false, nil, nil})
+	fake.returns("CreateDomainEvent", nil)
+	fake.returns("CreateSubscriptionEvent", nil)
+	cfg := &apiConfig{db: database.New(conn), dbConn: conn, metrics: newAppMetrics()}
+
+	cfg.expireSubscriptions(context.Background())
+
+	args := fake.argsOf("UpdateUserChirpyRed")
+	if len(args) != 2 || args[0] != false || args[1] != userID.String() {
+		t.Errorf("UpdateUserChirpyRed args = %v", args)
+	}
+	if !fake.didRun("CreateDomainEvent") {
+		t.Error("no user.downgraded event")
+	}
+	// subscription_id, event, status, period_start, period_end
+	args = fake.argsOf("CreateSubscriptionEvent")
+	if len(args) != 5 || args[1] != subscriptionEventExpired {
+		t.Errorf("CreateSubscriptionEvent args = %v", args)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gaschneider/go/httpserver/internal/tlsreload"
+)
+
+// middlewareAdminClientCert makes /admin/ require a client certificate
+// signed by one of the configured client CAs, on top of authorizeAdmin. The
+// TLS handshake only asks for a certificate, so other routes keep working
+// without one.
+func (cfg *apiConfig) middlewareAdminClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminClientCertRequired && isAdminPath(r.URL.Path) && !tlsreload.VerifiedClient(r.TLS) {
+			respondWithError(w, 403, "Client certificate required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdminPath matches the way the mux does, after cleaning, so "/x/../admin/"
+// can't get around the check.
+func isAdminPath(p string) bool {
+	p = path.Clean("/" + p)
+	return p == "/admin" || strings.HasPrefix(p, "/admin/")
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS
+// port. 308 keeps the method and body, unlike 301.
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			// an IPv6 address keeps its brackets without a port too
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminClientCert(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	// a handshake that didn't present a certificate, or plain HTTP
+	unverified := &tls.ConnectionState{}
+
+	tests := []struct {
+		path     string
+		state    *tls.ConnectionState
+		required bool
+		want     int
+	}{
+		{"/admin/metrics", verified, true, 200},
+		{"/admin/metrics", unverified, true, 403},
+		{"/admin/metrics", nil, true, 403},
+		{"/admin", unverified, true, 403},
+		{"/api/../admin/metrics", unverified, true, 403},
+		{"//admin/metrics", unverified, true, 403},
+		{"/api/chirps", unverified, true, 200},
+		{"/administrator", unverified, true, 200},
+		// without client CAs configured, authorizeAdmin alone decides
+		{"/admin/metrics", unverified, false, 200},
+	}
+
+	for _, tt := range tests {
+		cfg := &apiConfig{adminClientCertRequired: tt.required}
+		handler := cfg.middlewareAdminClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest("GET", "https://localhost"+tt.path, nil)
+		req.TLS = tt.state
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s (verified %v, required %v) = %d, want %d", tt.path, tt.state == verified, tt.required, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		host      string
+		httpsPort int
+		target    string
+		want      string
+	}{
+		{"example.com", 443, "/app/?a=1&b=2", "https://example.com/app/?a=1&b=2"},
+		{"example.com:80", 443, "/api/chirps", "https://example.com/api/chirps"},
+		{"example.com:8080", 8443, "/api/chirps", "https://example.com:8443/api/chirps"},
+		{"example.com", 8443, "/", "https://example.com:8443/"},
+		{"127.0.0.1:8080", 8443, "/", "https://127.0.0.1:8443/"},
+		{"[::1]:8080", 8443, "/admin/metrics", "https://[::1]:8443/admin/metrics"},
+		{"[::1]:8080", 443, "/", "https://[::1]/"},
+		{"[::1]", 443, "/", "https://[::1]/"},
+		{"example.com", 443, "/a%20b/c%2Fd", "https://example.com/a%20b/c%2Fd"},
+	}
+
+	for _, tt := range tests {
+		// 308 so a POST is repeated as a POST
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader("{}"))
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tt.httpsPort).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s%s: status = %d", tt.host, tt.target, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s%s with port %d: Location = %q, want %q", tt.host, tt.target, tt.httpsPort, got, tt.want)
+		}
+	}
+}
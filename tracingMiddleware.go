@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// middlewareTracing starts a server span per request, continuing the
+// caller's trace when it sent a traceparent header. The span is renamed to
+// the route pattern once the mux has matched one.
+func middlewareTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		traced := r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, traced)
+		// the mux sets the pattern on the request it was given
+		r.Pattern = traced.Pattern
+
+		if route := routePattern(traced); route != "" {
+			span.SetName(fmt.Sprintf("%s %s", r.Method, route))
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// hashPassword and checkPasswordHash trace bcrypt, which is slow on
+// purpose and often the bulk of a signup or login.
+func hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracing.Tracer().Start(ctx, "bcrypt hash")
+	hash, err := auth.HashPassword(password)
+	tracing.End(span, err)
+	return hash, err
+}
+
+func checkPasswordHash(ctx context.Context, password, hash string) error {
+	_, span := tracing.Tracer().Start(ctx, "bcrypt compare")
+	err := auth.CheckPasswordHash(password, hash)
+	// a wrong password isn't a failure of the span
+	span.End()
+	return err
+}
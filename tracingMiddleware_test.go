@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/tracing"
+	"github.com/gaschneider/go/httpserver/internal/webhook"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+// for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return exporter
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+// A request continues the caller's trace, and its queries and bcrypt run
+// show up as children of the server span.
+func TestRequestSpans(t *testing.T) {
+	exporter := recordSpans(t)
+
+	userID := uuid.New()
+	now := time.Now()
+	user := []driver.Value{userID.String(), now, now, "new@example.com", "hash", false, nil, nil}
+	fake, conn := newFakeDB(t)
+	fake.returns("GetUser", userColumns, user)
+	fake.returns("UpdateUserEmailAndPassword", userColumns, user)
+
+	cfg := &apiConfig{secret: testSecret, metrics: newAppMetrics()}
+	cfg.db = database.New(instrumentDB(conn, cfg.metrics))
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/users", cfg.updateUserHandler)
+	handler := middlewareTracing(mux)
+
+	token, err := auth.MakeJWT(userID, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		callerTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerSpan  = "00f067aa0ba902b7"
+	)
+	req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(`{"email":"new@example.com","password":"hunter2"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-"+callerTrace+"-"+callerSpan+"-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	spans := spansByName(exporter.GetSpans())
+	server, ok := spans["PUT /api/users"]
+	if !ok {
+		t.Fatalf("no server span named after the route, got %v", spans)
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span kind = %s", server.SpanKind)
+	}
+	if server.SpanContext.TraceID().String() != callerTrace || server.Parent.SpanID().String() != callerSpan {
+		t.Errorf("server span doesn't continue the caller's trace: trace %s parent %s", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+
+	for _, name := range []string{"db GetUser", "bcrypt hash", "db UpdateUserEmailAndPassword"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() || span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("%q isn't a child of the server span", name)
+		}
+	}
+}
+
+// Queries outside of a request, like the workers' polling, get no span.
+func TestUntracedQueriesHaveNoSpan(t *testing.T) {
+	exporter := recordSpans(t)
+
+	fake, conn := newFakeDB(t)
+	fake.returns("GetUser", userColumns)
+	db := database.New(tracing.InstrumentDB(conn))
+
+	db.GetUser(context.Background(), uuid.New())
+
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("got %d spans", len(spans))
+	}
+}
+
+// A webhook delivery is a client span under the caller's span, and the
+// subscriber gets a traceparent pointing at it.
+func TestWebhookSpan(t *testing.T) {
+	exporter := recordSpans(t)
+
+	received := make(chan string, 1)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	}))
+	defer subscriber.Close()
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "dispatch")
+	_, err := webhook.NewSender().Send(ctx, webhook.Delivery{
+		ID:     uuid.NewString(),
+		Event:  "chirp.created",
+		URL:    subscriber.URL,
+		Secret: "secret",
+		Body:   []byte(`{}`),
+	})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := spansByName(exporter.GetSpans())
+	span, ok := spans["webhook chirp.created"]
+	if !ok {
+		t.Fatalf("no webhook span, got %v", spans)
+	}
+	if span.SpanKind != trace.SpanKindClient || span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("webhook span kind %s, parent %s", span.SpanKind, span.Parent.SpanID())
+	}
+
+	want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if got := <-received; got != want {
+		t.Errorf("subscriber got traceparent %q, want %q", got, want)
+	}
+}
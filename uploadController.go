@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/gaschneider/go/httpserver/internal/media"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAvatarBytes          = 2 << 20
+	maxAttachmentBytes      = 5 << 20
+	maxAttachmentsPerChirp  = 4
+	avatarSize              = 256
+	attachmentThumbnailSize = 320
+)
+
+var avatarLimits = media.Limits{
+	MinWidth:  32,
+	MinHeight: 32,
+	MaxWidth:  4096,
+	MaxHeight: 4096,
+}
+
+var attachmentLimits = media.Limits{
+	MinWidth:  1,
+	MinHeight: 1,
+	MaxWidth:  8192,
+	MaxHeight: 8192,
+}
+
+type AttachmentDTO struct {
+	ID           uuid.UUID `json:"id"`
+	ContentType  string    `json:"content_type"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	SizeBytes    int64     `json:"size_bytes"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+func (cfg *apiConfig) attachmentToDTO(attachment database.ChirpAttachment) AttachmentDTO {
+	return AttachmentDTO{
+		ID:           attachment.ID,
+		ContentType:  attachment.ContentType,
+		Width:        attachment.Width,
+		Height:       attachment.Height,
+		SizeBytes:    attachment.SizeBytes,
+		URL:          cfg.blobStore.URL(attachment.StorageKey),
+		ThumbnailURL: cfg.blobStore.URL(attachment.ThumbnailKey),
+	}
+}
+
+func (cfg *apiConfig) avatarURL(avatarKey sql.NullString) string {
+	if !avatarKey.Valid {
+		return ""
+	}
+
+	return cfg.blobStore.URL(avatarKey.String)
+}
+
+// readUploadedImage pulls a single image out of a multipart form, enforcing the
+// size limit before anything is buffered.
+func readUploadedImage(w http.ResponseWriter, r *http.Request, field string, maxBytes int64, limits media.Limits) (media.Image, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+(64<<10))
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return media.Image{}, 413, fmt.Errorf("File is too large")
+		}
+		return media.Image{}, 400, fmt.Errorf("Missing %s file", field)
+	}
+	defer file.Close()
+
+	img, err := media.Load(http.MaxBytesReader(w, file, maxBytes), limits)
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return media.Image{}, 413, fmt.Errorf("File is too large")
+		}
+		return media.Image{}, 400, fmt.Errorf("Invalid image: %s", err)
+	}
+
+	return img, 0, nil
+}
+
+func (cfg *apiConfig) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	img, code, err := readUploadedImage(w, r, "avatar", maxAvatarBytes, avatarLimits)
+	if err != nil {
+		respondWithError(w, code, err.Error())
+		return
+	}
+
+	avatar, err := img.Thumbnail(avatarSize)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error resizing avatar", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	previous, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	key := fmt.Sprintf("avatars/%s/%s.%s", userID, uuid.New(), avatar.Extension)
+	err = cfg.blobStore.Put(r.Context(), key, bytes.NewReader(avatar.Data))
+	if err != nil {
+		requestLogger(r.Context()).Error("Error storing avatar", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.db.UpdateUserAvatar(r.Context(), database.UpdateUserAvatarParams{
+		AvatarKey: sql.NullString{String: key, Valid: true},
+		ID:        userID,
+	})
+	if err != nil {
+		cfg.blobStore.Delete(r.Context(), key)
+		respondWithError(w, 400, "Error updating user")
+		return
+	}
+
+	if previous.AvatarKey.Valid {
+		err = cfg.blobStore.Delete(r.Context(), previous.AvatarKey.String)
+		if err != nil {
+			requestLogger(r.Context()).Error("Error deleting old avatar", "key", previous.AvatarKey.String, "error", err)
+		}
+	}
+
+	userToJson := User{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		AvatarURL:   cfg.avatarURL(user.AvatarKey),
+	}
+
+	respondWithJSON(w, 200, userToJson)
+}
+
+func (cfg *apiConfig) uploadChirpAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	requestedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), requestedChirpID)
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	if chirp.UserID != userID {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	// checked again under a lock before inserting; this only saves reading
+	// an upload that can't be attached
+	count, err := cfg.db.CountAttachmentsByChirpId(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving attachments")
+		return
+	}
+
+	if count >= maxAttachmentsPerChirp {
+		respondWithError(w, 400, "Chirp already has the maximum number of attachments")
+		return
+	}
+
+	img, code, err := readUploadedImage(w, r, "image", maxAttachmentBytes, attachmentLimits)
+	if err != nil {
+		respondWithError(w, code, err.Error())
+		return
+	}
+
+	thumbnail, err := img.Thumbnail(attachmentThumbnailSize)
+	if err != nil {
+		requestLogger(r.Context()).Error("Error generating thumbnail", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	blobID := uuid.New()
+	key := fmt.Sprintf("chirps/%s/%s.%s", chirp.ID, blobID, img.Extension)
+	thumbnailKey := fmt.Sprintf("chirps/%s/%s_thumb.%s", chirp.ID, blobID, thumbnail.Extension)
+
+	err = cfg.blobStore.Put(r.Context(), key, bytes.NewReader(img.Data))
+	if err != nil {
+		requestLogger(r.Context()).Error("Error storing attachment", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	err = cfg.blobStore.Put(r.Context(), thumbnailKey, bytes.NewReader(thumbnail.Data))
+	if err != nil {
+		cfg.blobStore.Delete(r.Context(), key)
+		requestLogger(r.Context()).Error("Error storing thumbnail", "error", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	attachment, code, err := cfg.createChirpAttachment(r.Context(), database.CreateChirpAttachmentParams{
+		ChirpID:      chirp.ID,
+		ContentType:  img.ContentType,
+		SizeBytes:    int64(len(img.Data)),
+		Width:        int32(img.Width),
+		Height:       int32(img.Height),
+		StorageKey:   key,
+		ThumbnailKey: thumbnailKey,
+	})
+	if err != nil {
+		cfg.blobStore.Delete(r.Context(), key)
+		cfg.blobStore.Delete(r.Context(), thumbnailKey)
+		respondWithError(w, code, err.Error())
+		return
+	}
+
+	respondWithJSON(w, 201, cfg.attachmentToDTO(attachment))
+}
+
+// createChirpAttachment inserts the attachment unless the chirp is already
+// full. The chirp row stays locked from the count to the commit, so
+// concurrent uploads can't both take the last slot.
+func (cfg *apiConfig) createChirpAttachment(ctx context.Context, params database.CreateChirpAttachmentParams) (database.ChirpAttachment, int, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.ChirpAttachment{}, 500, errors.New("Something went wrong")
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	// the chirp may have been deleted since the handler looked it up
+	_, err = qtx.LockChirpForAttachment(ctx, params.ChirpID)
+	if err != nil {
+		return database.ChirpAttachment{}, 404, errors.New("Chirp not found")
+	}
+
+	count, err := qtx.CountAttachmentsByChirpId(ctx, params.ChirpID)
+	if err != nil {
+		return database.ChirpAttachment{}, 400, errors.New("Error retrieving attachments")
+	}
+	if count >= maxAttachmentsPerChirp {
+		return database.ChirpAttachment{}, 400, errors.New("Chirp already has the maximum number of attachments")
+	}
+
+	attachment, err := qtx.CreateChirpAttachment(ctx, params)
+	if err != nil {
+		return database.ChirpAttachment{}, 400, errors.New("Error creating attachment")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return database.ChirpAttachment{}, 500, errors.New("Something went wrong")
+	}
+	return attachment, 0, nil
+}
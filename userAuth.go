@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/google/uuid"
+)
+
+var errUserDeleted = errors.New("user deleted")
+
+// validateAccessToken checks an access JWT and that its user still exists.
+// Deleting an account only revokes refresh tokens, so without the lookup a
+// deleted user's access token would keep working until it expires.
+func (cfg *apiConfig) validateAccessToken(ctx context.Context, token string) (uuid.UUID, error) {
+	userID, err := auth.ValidateJWT(token, cfg.secret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	deleted, err := cfg.userDeleted(ctx, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if deleted {
+		return uuid.Nil, errUserDeleted
+	}
+
+	return userID, nil
+}
+
+// userDeleted reports whether the account is gone, either removed or
+// anonymized.
+func (cfg *apiConfig) userDeleted(ctx context.Context, userID uuid.UUID) (bool, error) {
+	user, err := cfg.db.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.DeletedAt.Valid, nil
+}
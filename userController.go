@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
@@ -17,6 +16,7 @@ type User struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 	Email       string    `json:"email"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
 }
 
 type LoggedUser struct {
@@ -35,18 +35,27 @@ func (cfg *apiConfig) createUsersHandler(w http.ResponseWriter, r *http.Request)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	hashed_password, err := auth.HashPassword(params.Password)
+	hashed_password, err := hashPassword(r.Context(), params.Password)
 	if err != nil {
 		respondWithError(w, 400, "Error creating user")
 		return
 	}
 
-	user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+
+	user, err := qtx.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          params.Email,
 		HashedPassword: hashed_password,
 	})
@@ -55,6 +64,18 @@ func (cfg *apiConfig) createUsersHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = publishEvent(r.Context(), qtx, eventUserCreated, user.ID, userEventPayload{UserID: user.ID})
+	if err != nil {
+		respondWithError(w, 400, "Error creating user")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
 	userToJson := User{
 		ID:          user.ID,
 		CreatedAt:   user.CreatedAt,
@@ -76,19 +97,21 @@ func (cfg *apiConfig) loginUserHandler(w http.ResponseWriter, r *http.Request) {
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
 	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
 		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	err = checkPasswordHash(r.Context(), params.Password, user.HashedPassword)
 	if err != nil {
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
 		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
@@ -127,11 +150,13 @@ func (cfg *apiConfig) loginUserHandler(w http.ResponseWriter, r *http.Request) {
 			UpdatedAt:   user.UpdatedAt,
 			Email:       user.Email,
 			IsChirpyRed: user.IsChirpyRed,
+			AvatarURL:   cfg.avatarURL(user.AvatarKey),
 		},
 		Token:        token,
 		RefreshToken: refreshToken,
 	}
 
+	cfg.metrics.logins.WithLabelValues("success").Inc()
 	respondWithJSON(w, 200, userToJson)
 }
 
@@ -195,7 +220,7 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+	userID, err := cfg.validateAccessToken(r.Context(), token)
 	if err != nil {
 		respondWithError(w, 401, "Unauthorized")
 		return
@@ -210,12 +235,12 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	hashed_password, err := auth.HashPassword(params.Password)
+	hashed_password, err := hashPassword(r.Context(), params.Password)
 	if err != nil {
 		respondWithError(w, 400, "Error updating user")
 		return
@@ -238,6 +263,7 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:   user.UpdatedAt,
 		Email:       user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		AvatarURL:   cfg.avatarURL(user.AvatarKey),
 	}
 
 	respondWithJSON(w, 200, userToJson)
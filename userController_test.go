@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var userColumns = []string{"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "avatar_key", "deleted_at"}
+
+// A deleted account's access token must stop working at once, or it could
+// put a new email and password on the anonymized row and take it back.
+func TestDeletedUserTokenRejected(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now()
+	anonymized := []driver.Value{userID.String(), now, now, "deleted-" + userID.String() + "@deleted.invalid", "unset", false, nil, now}
+
+	tests := []struct {
+		name    string
+		setup   func(db *fakeDB)
+		handler func(cfg *apiConfig) http.HandlerFunc
+		method  string
+		path    string
+		body    string
+		blocked string
+	}{
+		{
+			name:    "update after anonymize",
+			setup:   func(db *fakeDB) { db.returns("GetUser", userColumns, anonymized) },
+			handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.updateUserHandler },
+			method:  "PUT",
+			path:    "/api/users",
+			body:    `{"email":"back@example.com","password":"hunter2"}`,
+			blocked: "UpdateUserEmailAndPassword",
+		},
+		{
+			name:    "chirp after anonymize",
+			setup:   func(db *fakeDB) { db.returns("GetUser", userColumns, anonymized) },
+			handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.createChirpHandler },
+			method:  "POST",
+			path:    "/api/chirps",
+			body:    `{"body":"still here"}`,
+			blocked: "CreateChirp",
+		},
+		{
+			name:    "update after cascade delete",
+			setup:   func(db *fakeDB) { db.fails("GetUser", sql.ErrNoRows) },
+			handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.updateUserHandler },
+			method:  "PUT",
+			path:    "/api/users",
+			body:    `{"email":"back@example.com","password":"hunter2"}`,
+			blocked: "UpdateUserEmailAndPassword",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, conn := newFakeDB(t)
+			tt.setup(fake)
+			cfg := &apiConfig{db: database.New(conn), secret: testSecret}
+
+			token, err := auth.MakeJWT(userID, testSecret, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+
+			tt.handler(cfg)(rec, req)
+
+			if rec.Code != 401 {
+				t.Errorf("status = %d, want 401", rec.Code)
+			}
+			if fake.didRun(tt.blocked) {
+				t.Errorf("%s ran for a deleted user", tt.blocked)
+			}
+		})
+	}
+}
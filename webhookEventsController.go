@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookProviderPolka = "polka"
+
+	webhookEventPending   = "pending"
+	webhookEventProcessed = "processed"
+	// events we don't act on are kept so a redelivery is still recognised
+	webhookEventIgnored = "ignored"
+	webhookEventFailed  = "failed"
+
+	defaultWebhookEventsPageSize = 50
+	maxWebhookEventsPageSize     = 200
+)
+
+var errWebhookUserNotFound = errors.New("user not found")
+
+type WebhookEventDTO struct {
+	ID          uuid.UUID       `json:"id"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+}
+
+func webhookEventToDTO(event database.WebhookEvent) WebhookEventDTO {
+	dto := WebhookEventDTO{
+		ID:         event.ID,
+		ReceivedAt: event.ReceivedAt,
+		Provider:   event.Provider,
+		EventID:    event.EventID,
+		EventType:  event.EventType,
+		Payload:    json.RawMessage(event.Payload),
+		Status:     event.Status,
+		Attempts:   event.Attempts,
+		LastError:  event.LastError.String,
+	}
+	if event.ProcessedAt.Valid {
+		dto.ProcessedAt = &event.ProcessedAt.Time
+	}
+
+	return dto
+}
+
+// authorizeAdmin guards the admin API. Requests need the ADMIN_API_KEY as an
+// ApiKey authorization; without a key configured only dev servers allow it.
+func (cfg *apiConfig) authorizeAdmin(r *http.Request) bool {
+	if cfg.adminKey == "" {
+		return cfg.platform == "dev"
+	}
+
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminKey)) == 1
+}
+
+// getWebhookEventsHandler lists stored webhook events, by default the ones
+// that failed. ?status= picks another status, e.g. pending events that a
+// crash left half done.
+func (cfg *apiConfig) getWebhookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultWebhookEventsPageSize, maxWebhookEventsPageSize)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = webhookEventFailed
+	case webhookEventPending, webhookEventProcessed, webhookEventIgnored, webhookEventFailed:
+	default:
+		respondWithError(w, 400, "Invalid status")
+		return
+	}
+
+	events, err := cfg.db.GetWebhookEventsByStatus(r.Context(), database.GetWebhookEventsByStatusParams{
+		Status:     status,
+		PageLimit:  limit,
+		PageOffset: offset,
+	})
+	if err != nil {
+		respondWithError(w, 400, "Error retrieving webhook events")
+		return
+	}
+
+	respBody := make([]WebhookEventDTO, 0, len(events))
+	for _, event := range events {
+		respBody = append(respBody, webhookEventToDTO(event))
+	}
+
+	respondWithJSON(w, 200, respBody)
+}
+
+// reprocessWebhookEventHandler runs a failed or stuck event again, e.g. after
+// the bug that made it fail was fixed.
+func (cfg *apiConfig) reprocessWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
+		return
+	}
+
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid event ID")
+		return
+	}
+
+	event, err := cfg.db.GetWebhookEvent(r.Context(), eventID)
+	if err != nil {
+		respondWithError(w, 404, "Webhook event not found")
+		return
+	}
+
+	if event.Status == webhookEventProcessed || event.Status == webhookEventIgnored {
+		respondWithError(w, 409, "Webhook event was already processed")
+		return
+	}
+
+	// the outcome is stored on the event either way, so a failure here is
+	// reported through the event rather than the status code
+	err = cfg.processPolkaEvent(r.Context(), event)
+	if err != nil {
+		event, err = cfg.db.GetWebhookEvent(r.Context(), eventID)
+		if err != nil {
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		respondWithJSON(w, 422, webhookEventToDTO(event))
+		return
+	}
+
+	event, err = cfg.db.GetWebhookEvent(r.Context(), eventID)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, webhookEventToDTO(event))
+}
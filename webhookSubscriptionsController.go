@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWebhookSubscriptionsPerUser = 10
+	minWebhookSecretLength         = 16
+
+	defaultWebhookDeliveriesPageSize = 20
+	maxWebhookDeliveriesPageSize     = 100
+)
+
+type WebhookSubscriptionDTO struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	// only returned when the subscription is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookDeliveryAttemptDTO struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	DurationMs  int32     `json:"duration_ms"`
+	StatusCode  *int32    `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type WebhookDeliveryDTO struct {
+	ID            uuid.UUID                   `json:"id"`
+	CreatedAt     time.Time                   `json:"created_at"`
+	EventType     string                      `json:"event_type"`
+	Status        string                      `json:"status"`
+	Attempts      int32                       `json:"attempts"`
+	NextAttemptAt *time.Time                  `json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time                  `json:"delivered_at,omitempty"`
+	Log           []WebhookDeliveryAttemptDTO `json:"log"`
+}
+
+func webhookSubscriptionToDTO(subscription database.WebhookSubscription) WebhookSubscriptionDTO {
+	return WebhookSubscriptionDTO{
+		ID:         subscription.ID,
+		CreatedAt:  subscription.CreatedAt,
+		UpdatedAt:  subscription.UpdatedAt,
+		URL:        subscription.Url,
+		EventTypes: subscription.EventTypes,
+	}
+}
+
+// webhookOwnerFunc authenticates a webhook management request and returns
+// whose subscriptions it acts on: a user's, or the admin ones (no user).
+// It writes the error response itself when the request is rejected.
+type webhookOwnerFunc func(w http.ResponseWriter, r *http.Request) (uuid.NullUUID, bool)
+
+func (cfg *apiConfig) userWebhookOwner(w http.ResponseWriter, r *http.Request) (uuid.NullUUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return uuid.NullUUID{}, false
+	}
+
+	userID, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return uuid.NullUUID{}, false
+	}
+
+	return uuid.NullUUID{UUID: userID, Valid: true}, true
+}
+
+func (cfg *apiConfig) adminWebhookOwner(w http.ResponseWriter, r *http.Request) (uuid.NullUUID, bool) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, 403, "Access denied")
+		return uuid.NullUUID{}, false
+	}
+
+	return uuid.NullUUID{}, true
+}
+
+// ownedWebhookSubscription loads the subscription named in the path, making
+// sure it belongs to owner.
+func (cfg *apiConfig) ownedWebhookSubscription(w http.ResponseWriter, r *http.Request, owner uuid.NullUUID) (database.WebhookSubscription, bool) {
+	subscriptionID, err := uuid.Parse(r.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid subscription ID")
+		return database.WebhookSubscription{}, false
+	}
+
+	subscription, err := cfg.db.GetWebhookSubscription(r.Context(), subscriptionID)
+	if err != nil || subscription.UserID != owner {
+		respondWithError(w, 404, "Subscription not found")
+		return database.WebhookSubscription{}, false
+	}
+
+	return subscription, true
+}
+
+func (cfg *apiConfig) createWebhookSubscriptionHandler(ownerFunc webhookOwnerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := ownerFunc(w, r)
+		if !ok {
+			return
+		}
+
+		type parameters struct {
+			URL        string   `json:"url"`
+			Secret     string   `json:"secret"`
+			EventTypes []string `json:"event_types"`
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		if err != nil {
+			requestLogger(r.Context()).Error("Error decoding parameters", "error", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+
+		target, err := url.Parse(params.URL)
+		if err != nil || target.Host == "" || (target.Scheme != "https" && target.Scheme != "http") {
+			respondWithError(w, 400, "Invalid URL")
+			return
+		}
+		// users' payloads are signed but not encrypted, so they go over TLS
+		if owner.Valid && target.Scheme != "https" && cfg.platform != "dev" {
+			respondWithError(w, 400, "Webhook URLs must use https")
+			return
+		}
+
+		if len(params.EventTypes) == 0 {
+			respondWithError(w, 400, "At least one event type is required")
+			return
+		}
+		eventTypes := make([]string, 0, len(params.EventTypes))
+		seen := make(map[string]bool)
+		for _, eventType := range params.EventTypes {
+			if !outboundEventTypes[eventType] {
+				respondWithError(w, 400, "Unknown event type "+eventType)
+				return
+			}
+			if !seen[eventType] {
+				seen[eventType] = true
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+
+		secret := params.Secret
+		if secret == "" {
+			secret, err = auth.MakeRefreshToken()
+			if err != nil {
+				respondWithError(w, 500, "Something went wrong")
+				return
+			}
+		} else if len(secret) < minWebhookSecretLength {
+			respondWithError(w, 400, "Secret must be at least 16 characters")
+			return
+		}
+
+		if owner.Valid {
+			count, err := cfg.db.CountWebhookSubscriptionsByUser(r.Context(), owner)
+			if err != nil {
+				respondWithError(w, 400, "Error creating subscription")
+				return
+			}
+			if count >= maxWebhookSubscriptionsPerUser {
+				respondWithError(w, 409, "Too many webhook subscriptions")
+				return
+			}
+		}
+
+		subscription, err := cfg.db.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+			UserID:     owner,
+			Url:        target.String(),
+			Secret:     secret,
+			EventTypes: eventTypes,
+		})
+		if err != nil {
+			respondWithError(w, 400, "Error creating subscription")
+			return
+		}
+
+		respBody := webhookSubscriptionToDTO(subscription)
+		respBody.Secret = subscription.Secret
+		respondWithJSON(w, 201, respBody)
+	}
+}
+
+func (cfg *apiConfig) getWebhookSubscriptionsHandler(ownerFunc webhookOwnerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := ownerFunc(w, r)
+		if !ok {
+			return
+		}
+
+		var subscriptions []database.WebhookSubscription
+		var err error
+		if owner.Valid {
+			subscriptions, err = cfg.db.GetWebhookSubscriptionsByUser(r.Context(), owner)
+		} else {
+			subscriptions, err = cfg.db.GetAdminWebhookSubscriptions(r.Context())
+		}
+		if err != nil {
+			respondWithError(w, 400, "Error retrieving subscriptions")
+			return
+		}
+
+		respBody := make([]WebhookSubscriptionDTO, 0, len(subscriptions))
+		for _, subscription := range subscriptions {
+			respBody = append(respBody, webhookSubscriptionToDTO(subscription))
+		}
+
+		respondWithJSON(w, 200, respBody)
+	}
+}
+
+func (cfg *apiConfig) deleteWebhookSubscriptionHandler(ownerFunc webhookOwnerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := ownerFunc(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, ok := cfg.ownedWebhookSubscription(w, r, owner)
+		if !ok {
+			return
+		}
+
+		// pending deliveries are dropped along with the subscription
+		err := cfg.db.DeleteWebhookSubscription(r.Context(), subscription.ID)
+		if err != nil {
+			respondWithError(w, 400, "Error deleting subscription")
+			return
+		}
+
+		w.WriteHeader(204)
+	}
+}
+
+// getWebhookDeliveriesHandler is the delivery log of a subscription: its
+// deliveries, newest first, each with every attempt made to send it.
+func (cfg *apiConfig) getWebhookDeliveriesHandler(ownerFunc webhookOwnerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := ownerFunc(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, ok := cfg.ownedWebhookSubscription(w, r, owner)
+		if !ok {
+			return
+		}
+
+		limit, offset, err := parsePagination(r, defaultWebhookDeliveriesPageSize, maxWebhookDeliveriesPageSize)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+
+		deliveries, err := cfg.db.GetWebhookDeliveriesBySubscription(r.Context(), database.GetWebhookDeliveriesBySubscriptionParams{
+			SubscriptionID: subscription.ID,
+			PageLimit:      limit,
+			PageOffset:     offset,
+		})
+		if err != nil {
+			respondWithError(w, 400, "Error retrieving deliveries")
+			return
+		}
+
+		respBody, err := cfg.webhookDeliveriesToDTOs(r, deliveries)
+		if err != nil {
+			respondWithError(w, 400, "Error retrieving deliveries")
+			return
+		}
+
+		respondWithJSON(w, 200, respBody)
+	}
+}
+
+// retryWebhookDeliveryHandler puts a dead delivery back in the queue.
+func (cfg *apiConfig) retryWebhookDeliveryHandler(ownerFunc webhookOwnerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := ownerFunc(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, ok := cfg.ownedWebhookSubscription(w, r, owner)
+		if !ok {
+			return
+		}
+
+		deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+		if err != nil {
+			respondWithError(w, 400, "Invalid delivery ID")
+			return
+		}
+
+		delivery, err := cfg.db.GetWebhookDelivery(r.Context(), deliveryID)
+		if err != nil || delivery.SubscriptionID != subscription.ID {
+			respondWithError(w, 404, "Delivery not found")
+			return
+		}
+
+		delivery, err = cfg.db.RetryWebhookDelivery(r.Context(), delivery.ID)
+		if err != nil {
+			respondWithError(w, 409, "Only dead deliveries can be retried")
+			return
+		}
+
+		respBody, err := cfg.webhookDeliveriesToDTOs(r, []database.WebhookDelivery{delivery})
+		if err != nil {
+			respondWithError(w, 400, "Error retrieving delivery")
+			return
+		}
+
+		respondWithJSON(w, 200, respBody[0])
+	}
+}
+
+func (cfg *apiConfig) webhookDeliveriesToDTOs(r *http.Request, deliveries []database.WebhookDelivery) ([]WebhookDeliveryDTO, error) {
+	deliveryIDs := make([]uuid.UUID, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveryIDs = append(deliveryIDs, delivery.ID)
+	}
+
+	attempts, err := cfg.db.GetWebhookDeliveryAttempts(r.Context(), deliveryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	attemptsByDelivery := make(map[uuid.UUID][]WebhookDeliveryAttemptDTO)
+	for _, attempt := range attempts {
+		dto := WebhookDeliveryAttemptDTO{
+			AttemptedAt: attempt.AttemptedAt,
+			DurationMs:  attempt.DurationMs,
+			Error:       attempt.Error.String,
+		}
+		if attempt.StatusCode.Valid {
+			dto.StatusCode = &attempt.StatusCode.Int32
+		}
+		attemptsByDelivery[attempt.DeliveryID] = append(attemptsByDelivery[attempt.DeliveryID], dto)
+	}
+
+	dtos := make([]WebhookDeliveryDTO, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		dto := WebhookDeliveryDTO{
+			ID:        delivery.ID,
+			CreatedAt: delivery.CreatedAt,
+			EventType: delivery.EventType,
+			Status:    delivery.Status,
+			Attempts:  delivery.Attempts,
+			Log:       attemptsByDelivery[delivery.ID],
+		}
+		if dto.Log == nil {
+			dto.Log = []WebhookDeliveryAttemptDTO{}
+		}
+		if delivery.Status == webhookDeliveryPending {
+			dto.NextAttemptAt = &delivery.NextAttemptAt
+		}
+		if delivery.DeliveredAt.Valid {
+			dto.DeliveredAt = &delivery.DeliveredAt.Time
+		}
+		dtos = append(dtos, dto)
+	}
+
+	return dtos, nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gaschneider/go/httpserver/internal/auth"
+	"github.com/gaschneider/go/httpserver/internal/stream"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsAuthTimeout      = 10 * time.Second
+	wsPongWait         = 60 * time.Second
+	wsPingInterval     = 25 * time.Second
+	wsWriteTimeout     = 10 * time.Second
+	wsMaxMessageBytes  = 4 << 10
+	wsMaxSubscriptions = 50
+	wsOutboxSize       = 16
+
+	wsTopicTimeline      = "timeline"
+	wsTopicThread        = "thread"
+	wsTopicNotifications = "notifications"
+
+	// 4000-4999 are close codes for applications to define
+	wsCloseUnauthorized   = 4001
+	wsCloseTokenExpired   = 4002
+	wsCloseLagging        = 4003
+	wsCloseAccountDeleted = 4004
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// connections are authenticated with a bearer token rather than
+	// cookies, so a foreign page can't ride on the user's session
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// wsClientMessage is anything a client sends. Type is one of "auth",
+// "subscribe", "unsubscribe" and "ping"; ID is echoed back in the reply.
+type wsClientMessage struct {
+	Type     string     `json:"type"`
+	ID       string     `json:"id"`
+	Token    string     `json:"token"`
+	Topic    string     `json:"topic"`
+	AuthorID *uuid.UUID `json:"author_id"`
+	ChirpID  *uuid.UUID `json:"chirp_id"`
+}
+
+type wsServerMessage struct {
+	Type          string   `json:"type"`
+	ID            string   `json:"id,omitempty"`
+	Subscription  string   `json:"subscription,omitempty"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	Data          any      `json:"data,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// wsHub keeps track of open WebSocket connections. http.Server.Shutdown
+// doesn't wait for hijacked connections, so the hub is registered with
+// RegisterOnShutdown to close them with a proper close frame.
+type wsHub struct {
+	mu     sync.Mutex
+	conns  map[*wsConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{conns: make(map[*wsConn]struct{})}
+}
+
+func (h *wsHub) register(c *wsConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.conns[c] = struct{}{}
+	h.wg.Add(1)
+	return true
+}
+
+func (h *wsHub) unregister(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.conns[c]; ok {
+		delete(h.conns, c)
+		h.wg.Done()
+	}
+}
+
+func (h *wsHub) count() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return float64(len(h.conns))
+}
+
+// closeUser closes the user's connections on this instance, e.g. once the
+// account is deleted.
+func (h *wsHub) closeUser(userID uuid.UUID, code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.conns {
+		if c.userID == userID {
+			c.close(code, reason)
+		}
+	}
+}
+
+// Shutdown closes every connection and waits for their handlers to return.
+func (h *wsHub) Shutdown() {
+	h.mu.Lock()
+	h.closed = true
+	for c := range h.conns {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+	h.mu.Unlock()
+
+	h.wg.Wait()
+}
+
+type wsSubscription struct {
+	topic    string
+	authorID uuid.NullUUID
+	chirpID  uuid.UUID
+}
+
+func (s wsSubscription) matches(dto ChirpDTO) bool {
+	switch s.topic {
+	case wsTopicTimeline:
+		return !s.authorID.Valid || dto.UserId == s.authorID.UUID
+	case wsTopicThread:
+		return dto.ID == s.chirpID || (dto.OriginalChirp != nil && dto.OriginalChirp.ID == s.chirpID)
+	}
+	return false
+}
+
+type wsConn struct {
+	conn   *websocket.Conn
+	userID uuid.UUID
+	hidden map[uuid.UUID]bool
+
+	mu            sync.Mutex
+	subscriptions map[string]wsSubscription
+
+	outbox    chan wsServerMessage
+	renewed   chan time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// close sends a close frame and tears the connection down. It is safe to
+// call from any goroutine, any number of times.
+func (c *wsConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// send queues a reply for the write loop, which owns all data writes.
+func (c *wsConn) send(msg wsServerMessage) {
+	select {
+	case c.outbox <- msg:
+	case <-c.done:
+	}
+}
+
+// wsHandler upgrades to a WebSocket for live timelines, threads and
+// notifications. The JWT comes in the Authorization header or, for browsers
+// that can't set one, in an "auth" message that must be the first thing
+// sent. Sending another "auth" message with a fresh token before the current
+// one expires keeps the connection open; otherwise it is closed with 4002.
+// Deleting the account closes it with 4004.
+func (cfg *apiConfig) wsHandler(w http.ResponseWriter, r *http.Request) {
+	headerToken, _ := auth.GetBearerToken(r.Header)
+	if headerToken != "" {
+		_, err := cfg.validateAccessToken(r.Context(), headerToken)
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized")
+			return
+		}
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already wrote the error response
+		return
+	}
+
+	c := &wsConn{
+		conn:          conn,
+		subscriptions: make(map[string]wsSubscription),
+		outbox:        make(chan wsServerMessage, wsOutboxSize),
+		renewed:       make(chan time.Time, 1),
+		done:          make(chan struct{}),
+	}
+	defer c.close(websocket.CloseNormalClosure, "")
+
+	conn.SetReadLimit(wsMaxMessageBytes)
+
+	token := headerToken
+	if token == "" {
+		conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+		msg := wsClientMessage{}
+		err = conn.ReadJSON(&msg)
+		if err != nil || msg.Type != "auth" {
+			c.close(wsCloseUnauthorized, "authenticate first")
+			return
+		}
+		token = msg.Token
+	}
+
+	userID, expiresAt, err := cfg.validateWSToken(r.Context(), token, uuid.Nil)
+	if err != nil {
+		c.close(wsCloseUnauthorized, "invalid token")
+		return
+	}
+	c.userID = userID
+
+	c.hidden, err = cfg.streamHiddenUsers(r.Context(), uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		c.close(websocket.CloseInternalServerErr, "something went wrong")
+		return
+	}
+
+	if !cfg.wsHub.register(c) {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	defer cfg.wsHub.unregister(c)
+
+	chirps, _, _ := cfg.chirpStream.Subscribe("")
+	defer cfg.chirpStream.Unsubscribe(chirps)
+	notifications, _, _ := cfg.notificationStream.Subscribe("")
+	defer cfg.notificationStream.Unsubscribe(notifications)
+
+	c.send(wsServerMessage{Type: "authenticated"})
+
+	// the request's context ends when the handler returns
+	go cfg.wsReadLoop(context.WithoutCancel(r.Context()), c)
+	cfg.wsWriteLoop(r.Context(), c, chirps, notifications, expiresAt)
+}
+
+// validateWSToken checks a token for the connection. Once the connection
+// belongs to a user, a renewed token has to be for the same user.
+func (cfg *apiConfig) validateWSToken(ctx context.Context, token string, currentUser uuid.UUID) (uuid.UUID, time.Time, error) {
+	userID, err := cfg.validateAccessToken(ctx, token)
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	if currentUser != uuid.Nil && userID != currentUser {
+		return uuid.Nil, time.Time{}, errors.New("token is for another user")
+	}
+
+	expiresAt, err := auth.JWTExpiresAt(token, cfg.secret)
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+
+	return userID, expiresAt, nil
+}
+
+func (cfg *apiConfig) wsReadLoop(ctx context.Context, c *wsConn) {
+	defer c.close(websocket.CloseNormalClosure, "")
+
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		msg := wsClientMessage{}
+		err := c.conn.ReadJSON(&msg)
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch msg.Type {
+		case "ping":
+			c.send(wsServerMessage{Type: "pong", ID: msg.ID})
+		case "auth":
+			_, expiresAt, err := cfg.validateWSToken(ctx, msg.Token, c.userID)
+			if err != nil {
+				c.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "Invalid token"})
+				continue
+			}
+			// keep only the latest renewal if the write loop is behind
+			select {
+			case <-c.renewed:
+			default:
+			}
+			c.renewed <- expiresAt
+			c.send(wsServerMessage{Type: "authenticated", ID: msg.ID})
+		case "subscribe", "unsubscribe":
+			key, subscription, err := parseWSSubscription(msg)
+			if err != nil {
+				c.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+				continue
+			}
+
+			c.mu.Lock()
+			if msg.Type == "subscribe" {
+				_, exists := c.subscriptions[key]
+				if !exists && len(c.subscriptions) >= wsMaxSubscriptions {
+					c.mu.Unlock()
+					c.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "Too many subscriptions"})
+					continue
+				}
+				c.subscriptions[key] = subscription
+			} else {
+				delete(c.subscriptions, key)
+			}
+			c.mu.Unlock()
+
+			c.send(wsServerMessage{Type: msg.Type + "d", ID: msg.ID, Subscription: key})
+		default:
+			c.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "Unknown message type"})
+		}
+	}
+}
+
+// parseWSSubscription validates a (un)subscribe message and returns the key
+// the subscription is known by, e.g. "timeline", "timeline:<author id>" or
+// "thread:<chirp id>".
+func parseWSSubscription(msg wsClientMessage) (string, wsSubscription, error) {
+	switch msg.Topic {
+	case wsTopicTimeline:
+		if msg.AuthorID != nil {
+			return wsTopicTimeline + ":" + msg.AuthorID.String(), wsSubscription{
+				topic:    wsTopicTimeline,
+				authorID: uuid.NullUUID{UUID: *msg.AuthorID, Valid: true},
+			}, nil
+		}
+		return wsTopicTimeline, wsSubscription{topic: wsTopicTimeline}, nil
+	case wsTopicThread:
+		if msg.ChirpID == nil {
+			return "", wsSubscription{}, errors.New("Threads need a chirp_id")
+		}
+		return wsTopicThread + ":" + msg.ChirpID.String(), wsSubscription{
+			topic:   wsTopicThread,
+			chirpID: *msg.ChirpID,
+		}, nil
+	case wsTopicNotifications:
+		return wsTopicNotifications, wsSubscription{topic: wsTopicNotifications}, nil
+	}
+
+	return "", wsSubscription{}, errors.New("Unknown topic")
+}
+
+// wsWriteLoop is the only goroutine writing data frames to the connection.
+func (cfg *apiConfig) wsWriteLoop(ctx context.Context, c *wsConn, chirps, notifications *stream.Client, expiresAt time.Time) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+
+	write := func(msg wsServerMessage) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return c.conn.WriteJSON(msg) == nil
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.outbox:
+			if !write(msg) {
+				return
+			}
+		case event, ok := <-chirps.Events:
+			if !ok {
+				c.close(wsCloseLagging, "fell behind")
+				return
+			}
+			dto, visible := visibleStreamedChirp(event, c.hidden)
+			if !visible {
+				continue
+			}
+			var matched []string
+			c.mu.Lock()
+			for key, subscription := range c.subscriptions {
+				if subscription.matches(dto) {
+					matched = append(matched, key)
+				}
+			}
+			c.mu.Unlock()
+			if len(matched) > 0 && !write(wsServerMessage{Type: "chirp", Subscriptions: matched, Data: dto}) {
+				return
+			}
+		case event, ok := <-notifications.Events:
+			if !ok {
+				c.close(wsCloseLagging, "fell behind")
+				return
+			}
+			notification, isNotification := event.Data.(notificationStreamNotification)
+			if !isNotification || notification.RecipientID != c.userID {
+				continue
+			}
+			c.mu.Lock()
+			_, subscribed := c.subscriptions[wsTopicNotifications]
+			c.mu.Unlock()
+			if subscribed && !write(wsServerMessage{Type: "notification", Subscription: wsTopicNotifications, Data: notification.Notification}) {
+				return
+			}
+		case <-ping.C:
+			// deleteUserHandler closes the connections it can see; this
+			// catches the ones open on other instances. A failed lookup
+			// keeps the connection rather than dropping everyone at once.
+			deleted, err := cfg.userDeleted(ctx, c.userID)
+			if err == nil && deleted {
+				c.close(wsCloseAccountDeleted, "account deleted")
+				return
+			}
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				return
+			}
+		case renewedUntil := <-c.renewed:
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C:
+				default:
+				}
+			}
+			expiry.Reset(time.Until(renewedUntil))
+		case <-expiry.C:
+			c.close(wsCloseTokenExpired, "token expired")
+			return
+		}
+	}
+}